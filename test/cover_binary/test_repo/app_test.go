@@ -0,0 +1,25 @@
+package app
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppCoverage(t *testing.T) {
+	out, err := exec.Command(os.Getenv("DATA"), "wibble").Output()
+	if err != nil {
+		t.Fatalf("failed to run app: %s", err)
+	} else if string(out) != "wibble\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	// The test's own coverage isn't written until it exits, so this can only have come from the app, which inherits
+	// GOCOVERDIR from the test. Its coverage is merged with the test's once it finishes.
+	matches, err := filepath.Glob(filepath.Join(os.Getenv("GOCOVERDIR"), "covmeta.*"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(matches) == 0 {
+		t.Error("app didn't write any coverage data")
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/please-build/please-rules/cover-binary
+
+go 1.27.1
@@ -0,0 +1,29 @@
+package diagnostics
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGoroutinesFile(t *testing.T) {
+	contents, err := os.ReadFile(os.Getenv("TEST_GOROUTINES_FILE"))
+	if err != nil {
+		t.Fatalf("failed to read goroutines file: %s", err)
+	}
+	if len(contents) != 0 {
+		t.Errorf("expected goroutines file to be empty, was:\n%s", contents)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	if timeout := os.Getenv("TEST_TIMEOUT"); timeout != "60" {
+		t.Errorf("unexpected timeout %s", timeout)
+	}
+}
+
+// The leak check should wait for this to exit
+func TestGoroutineExits(t *testing.T) {
+	done := make(chan struct{})
+	go func() { close(done) }()
+	<-done
+}
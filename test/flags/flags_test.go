@@ -0,0 +1,25 @@
+package flags
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestTypedFlags(t *testing.T) {
+	if !testing.Short() {
+		t.Error("expected -test.short to be set")
+	}
+	for name, expected := range map[string]string{
+		"test.count":    "2",
+		"test.cpu":      "1,2",
+		"test.failfast": "true",
+		"test.parallel": "3",
+		"test.shuffle":  "on",
+		// The rule's timeout, less a few seconds for the test to dump its goroutines before Please kills it.
+		"test.timeout": "55s",
+	} {
+		if value := flag.Lookup(name).Value.String(); value != expected {
+			t.Errorf("expected -%s=%s, was %s", name, expected, value)
+		}
+	}
+}
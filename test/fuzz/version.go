@@ -0,0 +1,24 @@
+package fuzz
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseVersion parses a version like 1.2.3 into its major, minor and patch numbers.
+func ParseVersion(s string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
@@ -0,0 +1,21 @@
+package fuzz
+
+import (
+	"fmt"
+	"testing"
+)
+
+func FuzzParseVersion(f *testing.F) {
+	f.Add("1.2.3")
+	f.Fuzz(func(t *testing.T, s string) {
+		v, err := ParseVersion(s)
+		if err != nil {
+			return
+		}
+		// Anything that parses must parse the same once it's formatted again
+		formatted := fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+		if v2, err := ParseVersion(formatted); err != nil || v2 != v {
+			t.Errorf("%q parsed as %v but %q parsed as %v, %v", s, v, formatted, v2, err)
+		}
+	})
+}
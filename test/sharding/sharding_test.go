@@ -0,0 +1,28 @@
+package sharding
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestShardIndex(t *testing.T) {
+	index, err := strconv.Atoi(os.Getenv("TEST_SHARD_INDEX"))
+	if err != nil {
+		t.Fatalf("TEST_SHARD_INDEX isn't set: %s", err)
+	}
+	if index < 0 || index >= 3 {
+		t.Errorf("unexpected shard index %d", index)
+	}
+}
+
+func TestTotalShards(t *testing.T) {
+	if total := os.Getenv("TEST_TOTAL_SHARDS"); total != "3" {
+		t.Errorf("unexpected shard count %s", total)
+	}
+}
+
+func TestOne(t *testing.T)   {}
+func TestTwo(t *testing.T)   {}
+func TestThree(t *testing.T) {}
+func TestFour(t *testing.T)  {}
@@ -0,0 +1,23 @@
+package results
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSubtests(t *testing.T) {
+	for _, name := range []string{"a", "b", "c"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			t.Logf("running %s", name)
+		})
+	}
+}
+
+func TestOutputWithoutNewline(t *testing.T) {
+	fmt.Print("no newline here")
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("skipped on purpose")
+}
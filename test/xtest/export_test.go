@@ -0,0 +1,4 @@
+package xtest
+
+// Salutation is exported here for the external tests, which are compiled against the internal test package.
+const Salutation = greeting
@@ -0,0 +1,8 @@
+package xtest
+
+// Greeting returns a greeting for the given name.
+func Greeting(name string) string {
+	return greeting + ", " + name
+}
+
+const greeting = "Hello"
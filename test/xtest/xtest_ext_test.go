@@ -0,0 +1,25 @@
+package xtest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/please-build/go-rules/test/xtest"
+)
+
+func TestGreetingExternal(t *testing.T) {
+	if s := xtest.Greeting("world"); s != "Hello, world" {
+		t.Errorf("unexpected greeting %s", s)
+	}
+}
+
+func TestSalutation(t *testing.T) {
+	if xtest.Salutation != "Hello" {
+		t.Errorf("unexpected salutation %s", xtest.Salutation)
+	}
+}
+
+func ExampleGreeting() {
+	fmt.Println(xtest.Greeting("world"))
+	// Output: Hello, world
+}
@@ -0,0 +1,9 @@
+package xtest
+
+import "testing"
+
+func TestGreetingInternal(t *testing.T) {
+	if greeting != "Hello" {
+		t.Errorf("unexpected greeting %s", greeting)
+	}
+}
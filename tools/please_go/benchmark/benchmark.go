@@ -0,0 +1,199 @@
+// Package benchmark parses the output of Go benchmarks into structured results, and compares sets of them.
+//
+// Results are read in the Go benchmark format described at
+// https://go.googlesource.com/proposal/+/master/design/14313-benchmark-format.md, as printed by `go test -bench`.
+package benchmark
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Results are the results of a benchmark run, along with the configuration they ran in (e.g. goos, goarch and pkg).
+type Results struct {
+	Config  map[string]string `json:"config,omitempty"`
+	Results []Result          `json:"results"`
+}
+
+// A Result is a single result line from a benchmark.
+type Result struct {
+	// Name is the full name of the benchmark, including any -N GOMAXPROCS suffix.
+	Name       string  `json:"name"`
+	Iterations int     `json:"iterations"`
+	Values     []Value `json:"values"`
+}
+
+// A Value is a single measurement in a result, e.g. 120 ns/op or 3 allocs/op.
+type Value struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// Parse reads benchmark results from the output of a benchmark. Anything other than configuration and result lines,
+// like test output or logging, is ignored.
+func Parse(r io.Reader) (*Results, error) {
+	results := &Results{Config: map[string]string{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if result, ok := parseResult(line); ok {
+			results.Results = append(results.Results, result)
+		} else if key, value, ok := parseConfig(line); ok {
+			results.Config[key] = value
+		}
+	}
+	return results, scanner.Err()
+}
+
+// parseResult parses a result line, which is a benchmark name, the number of iterations and then pairs of values and
+// units.
+func parseResult(line string) (Result, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields)%2 != 0 || !isBenchmarkName(fields[0]) {
+		return Result{}, false
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Result{}, false
+	}
+	result := Result{Name: fields[0], Iterations: iterations}
+	for i := 2; i < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return Result{}, false
+		}
+		result.Values = append(result.Values, Value{Value: value, Unit: fields[i+1]})
+	}
+	return result, true
+}
+
+// isBenchmarkName returns true if the name looks like a benchmark, in the same way go test decides what's a benchmark
+// function.
+func isBenchmarkName(name string) bool {
+	rest, ok := strings.CutPrefix(name, "Benchmark")
+	if !ok {
+		return false
+	} else if rest == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return !unicode.IsLower(r)
+}
+
+// parseConfig parses a configuration line like "goos: linux". Keys start with a lower case letter and don't contain
+// any spaces or upper case letters.
+func parseConfig(line string) (string, string, bool) {
+	key, value, ok := strings.Cut(line, ":")
+	if !ok || key == "" || !unicode.IsLower(rune(key[0])) {
+		return "", "", false
+	}
+	for _, r := range key {
+		if unicode.IsSpace(r) || unicode.IsUpper(r) {
+			return "", "", false
+		}
+	}
+	return key, strings.TrimSpace(value), true
+}
+
+// Read reads results from a file, which can either be benchmark output or the JSON written by WriteJSON.
+func Read(filename string) (*Results, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(filename, ".json") {
+		results := &Results{}
+		if err := json.NewDecoder(f).Decode(results); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+		return results, nil
+	}
+	return Parse(f)
+}
+
+// ReadOutput parses benchmark output from the given files as one set of results, or from stdin if there aren't any.
+func ReadOutput(filenames []string) (*Results, error) {
+	if len(filenames) == 0 {
+		return Parse(os.Stdin)
+	}
+	readers := make([]io.Reader, len(filenames))
+	for i, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		readers[i] = f
+	}
+	return Parse(io.MultiReader(readers...))
+}
+
+// WriteFiles writes the results in the Go benchmark format to textFile and as JSON to jsonFile. Either can be empty to
+// skip that format, and if both are the results are written to stdout in the Go benchmark format.
+func (results *Results) WriteFiles(textFile, jsonFile string) error {
+	if textFile == "" && jsonFile == "" {
+		return results.WriteText(os.Stdout)
+	}
+	if err := writeFile(textFile, results.WriteText); err != nil {
+		return err
+	}
+	return writeFile(jsonFile, results.WriteJSON)
+}
+
+// writeFile writes a file with the given function, if a filename is given.
+func writeFile(filename string, write func(io.Writer) error) error {
+	if filename == "" {
+		return nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteText writes the results in the Go benchmark format.
+func (results *Results) WriteText(w io.Writer) error {
+	keys := make([]string, 0, len(results.Config))
+	for key := range results.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", key, results.Config[key]); err != nil {
+			return err
+		}
+	}
+	for _, result := range results.Results {
+		line := fmt.Sprintf("%s\t%d", result.Name, result.Iterations)
+		for _, value := range result.Values {
+			line += fmt.Sprintf("\t%s %s", strconv.FormatFloat(value.Value, 'f', -1, 64), value.Unit)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the results as JSON.
+func (results *Results) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(results)
+}
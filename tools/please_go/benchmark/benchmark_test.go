@@ -0,0 +1,60 @@
+package benchmark
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testData = "tools/please_go/benchmark/test_data"
+
+func TestParse(t *testing.T) {
+	results, err := Parse(strings.NewReader(`goos: linux
+pkg: example.com/pkg
+=== RUN   TestSomething
+BenchmarkFoo-8   	 1000000	      1052 ns/op	      64 B/op	       2 allocs/op
+Benchmarking is fun
+Benchmarkfoo 100 10 ns/op
+BenchmarkBar/size=10-8 	  500	  2500.5 ns/op	  12.50 MB/s	 3.000 widgets/op
+BenchmarkBaz 	  100	  not-a-number ns/op
+PASS
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"goos": "linux", "pkg": "example.com/pkg"}, results.Config)
+	assert.Equal(t, []Result{
+		{
+			Name:       "BenchmarkFoo-8",
+			Iterations: 1000000,
+			Values:     []Value{{1052, "ns/op"}, {64, "B/op"}, {2, "allocs/op"}},
+		},
+		{
+			Name:       "BenchmarkBar/size=10-8",
+			Iterations: 500,
+			Values:     []Value{{2500.5, "ns/op"}, {12.5, "MB/s"}, {3, "widgets/op"}},
+		},
+	}, results.Results)
+}
+
+func TestRoundTrip(t *testing.T) {
+	results, err := Read(testData + "/old.txt")
+	require.NoError(t, err)
+	require.Len(t, results.Results, 10)
+
+	var text bytes.Buffer
+	require.NoError(t, results.WriteText(&text))
+	fromText, err := Parse(&text)
+	require.NoError(t, err)
+	assert.Equal(t, results, fromText)
+
+	dir := t.TempDir()
+	require.NoError(t, results.WriteFiles(dir+"/results.txt", dir+"/results.json"))
+	fromJSON, err := Read(dir + "/results.json")
+	require.NoError(t, err)
+	assert.Equal(t, results, fromJSON)
+	fromFile, err := ReadOutput([]string{dir + "/results.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, results, fromFile)
+}
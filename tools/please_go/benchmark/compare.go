@@ -0,0 +1,280 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A Comparison is the comparison of one metric of a benchmark between two sets of results.
+type Comparison struct {
+	Name string  `json:"name"`
+	Unit string  `json:"unit"`
+	Old  Summary `json:"old"`
+	New  Summary `json:"new"`
+	// Delta is the percentage change in the mean from old to new, or zero if the change isn't significant.
+	Delta float64 `json:"delta"`
+	// P is the p-value of a Mann-Whitney U test of the two samples.
+	P float64 `json:"p"`
+	// Significant is true if the samples are different at the requested significance level.
+	Significant bool `json:"significant"`
+	// Regression is true if the change is significant and in the worse direction for the unit.
+	Regression bool `json:"regression"`
+}
+
+// A Summary summarises the samples of a metric.
+type Summary struct {
+	Mean float64 `json:"mean"`
+	// Deviation is the largest deviation of a sample from the mean, as a percentage of it.
+	Deviation float64   `json:"deviation"`
+	Samples   []float64 `json:"samples"`
+}
+
+// Compare compares each metric of the benchmarks present in both sets of results. Changes are significant if the
+// p-value of a Mann-Whitney U test of the samples is below alpha.
+func Compare(oldResults, newResults *Results, alpha float64) []*Comparison {
+	oldSamples, order := samples(oldResults)
+	newSamples, _ := samples(newResults)
+	comparisons := []*Comparison{}
+	for _, k := range order {
+		n, present := newSamples[k]
+		if !present {
+			continue
+		}
+		o := oldSamples[k]
+		c := &Comparison{
+			Name: k.name,
+			Unit: k.unit,
+			Old:  summarise(o),
+			New:  summarise(n),
+			P:    mannWhitneyU(o, n),
+		}
+		c.Significant = c.P < alpha
+		if c.Significant && c.Old.Mean != 0 {
+			c.Delta = (c.New.Mean - c.Old.Mean) / c.Old.Mean * 100
+			c.Regression = (c.Delta > 0) != higherIsBetter(c.Unit)
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+// CompareFiles reads two sets of results with Read and compares them.
+func CompareFiles(oldFile, newFile string, alpha float64) ([]*Comparison, error) {
+	oldResults, err := Read(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newResults, err := Read(newFile)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(oldResults, newResults, alpha), nil
+}
+
+// CheckRegressions returns an error describing each comparison that regressed by at least threshold percent.
+func CheckRegressions(comparisons []*Comparison, threshold float64) error {
+	var errs []error
+	for _, c := range comparisons {
+		if c.Regression && math.Abs(c.Delta) >= threshold {
+			errs = append(errs, fmt.Errorf("%s %s regressed by %.2f%%", c.Name, c.Unit, math.Abs(c.Delta)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type metric struct {
+	name, unit string
+}
+
+// samples groups the values in the results by benchmark and unit, returning them in the order they first appear.
+func samples(results *Results) (map[metric][]float64, []metric) {
+	m := map[metric][]float64{}
+	var order []metric
+	for _, result := range results.Results {
+		for _, value := range result.Values {
+			k := metric{name: result.Name, unit: value.Unit}
+			if _, present := m[k]; !present {
+				order = append(order, k)
+			}
+			m[k] = append(m[k], value.Value)
+		}
+	}
+	return m, order
+}
+
+func summarise(samples []float64) Summary {
+	s := Summary{Samples: samples}
+	for _, x := range samples {
+		s.Mean += x
+	}
+	s.Mean /= float64(len(samples))
+	if s.Mean != 0 {
+		for _, x := range samples {
+			s.Deviation = math.Max(s.Deviation, math.Abs(x-s.Mean)/s.Mean*100)
+		}
+	}
+	return s
+}
+
+// higherIsBetter returns true if an increase in the unit is an improvement, which is the case for rates like MB/s.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+// mannWhitneyU returns the two-sided p-value of a Mann-Whitney U test that the two samples come from the same
+// distribution. Small samples without ties use the exact distribution of U, and anything else uses the normal
+// approximation with a correction for ties.
+func mannWhitneyU(a, b []float64) float64 {
+	n1, n2 := len(a), len(b)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type sample struct {
+		value float64
+		first bool
+	}
+	all := make([]sample, 0, n1+n2)
+	for _, x := range a {
+		all = append(all, sample{value: x, first: true})
+	}
+	for _, x := range b {
+		all = append(all, sample{value: x})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// Assign ranks, averaging them over runs of equal values.
+	var r1, tieCorrection float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for _, s := range all[i:j] {
+			if s.first {
+				r1 += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieCorrection += t*t*t - t
+		}
+		i = j
+	}
+	u := r1 - float64(n1*(n1+1))/2
+	u = math.Min(u, float64(n1*n2)-u)
+
+	if !ties && n1+n2 <= 50 {
+		return math.Min(1, 2*exactUCDF(int(u), n1, n2))
+	}
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance == 0 {
+		return 1
+	}
+	// Continuity correction, since U is discrete.
+	z := (u - mean + 0.5) / math.Sqrt(variance)
+	return math.Min(1, 2*normalCDF(z))
+}
+
+// exactUCDF returns P(U <= u) for samples of size n1 and n2 with no ties, by counting the arrangements of the samples
+// that give each value of U.
+func exactUCDF(u, n1, n2 int) float64 {
+	// counts[i][j] holds the number of arrangements of i and j values giving each value of U.
+	counts := make([][][]float64, n1+1)
+	for i := range counts {
+		counts[i] = make([][]float64, n2+1)
+		for j := range counts[i] {
+			counts[i][j] = make([]float64, i*j+1)
+			if i == 0 || j == 0 {
+				counts[i][j][0] = 1
+				continue
+			}
+			// The largest value is either from the first sample, which then beats all j of the second, or not.
+			for k := range counts[i][j] {
+				if k >= j && k-j < len(counts[i-1][j]) {
+					counts[i][j][k] += counts[i-1][j][k-j]
+				}
+				if k < len(counts[i][j-1]) {
+					counts[i][j][k] += counts[i][j-1][k]
+				}
+			}
+		}
+	}
+	var below, total float64
+	for k, c := range counts[n1][n2] {
+		if k <= u {
+			below += c
+		}
+		total += c
+	}
+	return below / total
+}
+
+func normalCDF(z float64) float64 {
+	return 0.5 * math.Erfc(-z/math.Sqrt2)
+}
+
+// WriteComparisons writes the comparisons in the given format, which is either "text" for a table or "json".
+func WriteComparisons(w io.Writer, comparisons []*Comparison, format string) error {
+	if format == "json" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(comparisons)
+	}
+	return WriteTable(w, comparisons)
+}
+
+// WriteTable writes the comparisons as a table, one section per unit, in the style of benchstat.
+func WriteTable(w io.Writer, comparisons []*Comparison) error {
+	var units []string
+	for _, c := range comparisons {
+		if !slices.Contains(units, c.Unit) {
+			units = append(units, c.Unit)
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, unit := range units {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "name\told %s\tnew %s\tdelta\n", unit, unit)
+		for _, c := range comparisons {
+			if c.Unit != unit {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, formatSummary(c.Old), formatSummary(c.New), formatDelta(c))
+		}
+	}
+	return tw.Flush()
+}
+
+func formatSummary(s Summary) string {
+	return fmt.Sprintf("%s ±%.0f%%", formatValue(s.Mean), s.Deviation)
+}
+
+// formatValue formats a value to four significant figures, without resorting to an exponent for large ones.
+func formatValue(x float64) string {
+	if math.Abs(x) >= 1000 {
+		return strconv.FormatFloat(x, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(x, 'g', 4, 64)
+}
+
+func formatDelta(c *Comparison) string {
+	n := fmt.Sprintf("(p=%.3f n=%d+%d)", c.P, len(c.Old.Samples), len(c.New.Samples))
+	if !c.Significant {
+		return "~ " + n
+	}
+	return fmt.Sprintf("%+.2f%% %s", c.Delta, n)
+}
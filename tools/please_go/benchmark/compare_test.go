@@ -0,0 +1,112 @@
+package benchmark
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	oldResults, err := Read(testData + "/old.txt")
+	require.NoError(t, err)
+	newResults, err := Read(testData + "/new.txt")
+	require.NoError(t, err)
+
+	comparisons := Compare(oldResults, newResults, 0.05)
+	require.Len(t, comparisons, 5)
+
+	nsop := comparisons[0]
+	assert.Equal(t, "BenchmarkParse-8", nsop.Name)
+	assert.Equal(t, "ns/op", nsop.Unit)
+	assert.InDelta(t, 10232, nsop.Old.Mean, 0.01)
+	assert.InDelta(t, 12410, nsop.New.Mean, 0.01)
+	assert.InDelta(t, 21.29, nsop.Delta, 0.01)
+	assert.InDelta(t, 0.0079, nsop.P, 0.0001)
+	assert.True(t, nsop.Significant)
+	assert.True(t, nsop.Regression)
+
+	// Every sample is tied within each set, but the sets still differ.
+	allocs := comparisons[2]
+	assert.Equal(t, "allocs/op", allocs.Unit)
+	assert.True(t, allocs.Significant)
+	assert.True(t, allocs.Regression)
+
+	for _, c := range comparisons[3:] {
+		assert.Equal(t, "BenchmarkCopy-8", c.Name)
+		assert.False(t, c.Significant, c.Unit)
+		assert.False(t, c.Regression, c.Unit)
+		assert.Zero(t, c.Delta, c.Unit)
+	}
+}
+
+func TestCompareHigherIsBetter(t *testing.T) {
+	oldResults := &Results{Results: []Result{
+		{Name: "BenchmarkCopy", Values: []Value{{100, "MB/s"}}},
+		{Name: "BenchmarkCopy", Values: []Value{{101, "MB/s"}}},
+		{Name: "BenchmarkCopy", Values: []Value{{102, "MB/s"}}},
+		{Name: "BenchmarkCopy", Values: []Value{{103, "MB/s"}}},
+	}}
+	newResults := &Results{Results: []Result{
+		{Name: "BenchmarkCopy", Values: []Value{{200, "MB/s"}}},
+		{Name: "BenchmarkCopy", Values: []Value{{201, "MB/s"}}},
+		{Name: "BenchmarkCopy", Values: []Value{{202, "MB/s"}}},
+		{Name: "BenchmarkCopy", Values: []Value{{203, "MB/s"}}},
+		{Name: "BenchmarkMissing", Values: []Value{{1, "MB/s"}}},
+	}}
+	comparisons := Compare(oldResults, newResults, 0.05)
+	require.Len(t, comparisons, 1)
+	assert.True(t, comparisons[0].Significant)
+	assert.False(t, comparisons[0].Regression)
+}
+
+func TestCheckRegressions(t *testing.T) {
+	comparisons, err := CompareFiles(testData+"/old.txt", testData+"/new.txt", 0.05)
+	require.NoError(t, err)
+
+	err = CheckRegressions(comparisons, 0)
+	require.Error(t, err)
+	assert.Equal(t, `BenchmarkParse-8 ns/op regressed by 21.29%
+BenchmarkParse-8 B/op regressed by 12.50%
+BenchmarkParse-8 allocs/op regressed by 16.67%`, err.Error())
+
+	err = CheckRegressions(comparisons, 15)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "B/op")
+
+	assert.NoError(t, CheckRegressions(comparisons, 25))
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	// Exact, the samples are completely separated so only 2 of the 70 arrangements are at least as extreme.
+	assert.InDelta(t, 2.0/70, mannWhitneyU([]float64{1, 2, 3, 4}, []float64{5, 6, 7, 8}), 1e-9)
+	assert.InDelta(t, 1, mannWhitneyU([]float64{1, 4, 5, 8}, []float64{2, 3, 6, 7}), 1e-9)
+	// With ties, this falls back to the normal approximation.
+	assert.InDelta(t, 0.0147, mannWhitneyU([]float64{1, 1, 2, 2, 3}, []float64{3, 4, 4, 5, 5}), 0.0001)
+	assert.Equal(t, 1.0, mannWhitneyU([]float64{1, 1}, []float64{1, 1}))
+	assert.Equal(t, 1.0, mannWhitneyU(nil, []float64{1}))
+}
+
+func TestWriteTable(t *testing.T) {
+	oldResults, err := Read(testData + "/old.txt")
+	require.NoError(t, err)
+	newResults, err := Read(testData + "/new.txt")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteTable(&buf, Compare(oldResults, newResults, 0.05)))
+	assert.Equal(t, `name              old ns/op  new ns/op  delta
+BenchmarkParse-8  10232 ±1%  12410 ±1%  +21.29% (p=0.008 n=5+5)
+BenchmarkCopy-8   24016 ±1%  24010 ±0%  ~ (p=1.000 n=5+5)
+
+name              old B/op  new B/op  delta
+BenchmarkParse-8  4096 ±0%  4608 ±0%  +12.50% (p=0.004 n=5+5)
+
+name              old allocs/op  new allocs/op  delta
+BenchmarkParse-8  12 ±0%         14 ±0%         +16.67% (p=0.004 n=5+5)
+
+name             old MB/s   new MB/s   delta
+BenchmarkCopy-8  170.5 ±1%  170.5 ±0%  ~ (p=1.000 n=5+5)
+`, buf.String())
+}
@@ -0,0 +1,161 @@
+// Package download implements downloading third-party Go modules via the module proxy protocol, much like
+// `go mod download` but without needing a module cache or a go.mod to run from.
+package download
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/zip"
+
+	"github.com/please-build/go-rules/tools/please_go/generate/gomoddeps"
+)
+
+// Config controls where modules are downloaded from and how they're verified. The fields mirror the environment
+// variables the go tool uses for the same purposes.
+type Config struct {
+	// GoProxy is the list of proxies to try, as GOPROXY
+	GoProxy string
+	// GoNoProxy are module path patterns to download directly rather than via a proxy, as GONOPROXY
+	GoNoProxy string
+	// GoSumDB is the checksum database to verify modules against, as GOSUMDB
+	GoSumDB string
+	// GoNoSumDB are module path patterns not to verify against the checksum database, as GONOSUMDB
+	GoNoSumDB string
+	// Netrc is the path to a .netrc file with credentials for module proxies and private repos, as NETRC
+	Netrc string
+	// GoTool is the go binary, used for modules that must be fetched directly from version control
+	GoTool string
+	// GoSum is the path to a go.sum file to verify modules against. If set, every module must have an entry in it and
+	// the checksum database isn't consulted.
+	GoSum string
+	// ModFile is the host repo's go.mod. If set, modules it excludes can't be downloaded when validating.
+	ModFile string
+	// Validate checks the module isn't excluded by the host go.mod
+	Validate bool
+	// StrictRetractions fails the download, rather than warning, if the module has been retracted
+	StrictRetractions bool
+	// Warnings, e.g. that the module has been retracted, are written here. Defaults to stderr.
+	Warnings io.Writer
+}
+
+// ConfigFromEnv returns a configuration based on the go tool's environment variables, with the same defaults. GOFLAGS
+// isn't read: none of the flags it can hold change how modules are fetched or verified now that -insecure has been
+// replaced by GOINSECURE, which the go tool reads itself when fetching modules directly.
+func ConfigFromEnv() Config {
+	private := os.Getenv("GOPRIVATE")
+	return Config{
+		GoProxy:   getenv("GOPROXY", "https://proxy.golang.org,direct"),
+		GoNoProxy: getenv("GONOPROXY", private),
+		GoSumDB:   getenv("GOSUMDB", "sum.golang.org"),
+		GoNoSumDB: getenv("GONOSUMDB", private),
+		Netrc:     os.Getenv("NETRC"),
+		GoTool:    "go",
+	}
+}
+
+func getenv(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// Download downloads a module into the given output directory. Once verified, the module is extracted with its
+// canonical layout, the given paths are stripped from it and the patches applied.
+func Download(config Config, mod module.Version, out string, strip, patches []string) error {
+	if err := module.Check(mod.Path, mod.Version); err != nil {
+		return err
+	}
+	if config.Validate && config.ModFile != "" {
+		host, err := gomoddeps.ReadGoMod(config.ModFile, false)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", config.ModFile, err)
+		} else if host.Excluded(mod) {
+			return fmt.Errorf("%s is excluded by %s", mod, config.ModFile)
+		}
+	}
+	tmp, err := os.MkdirTemp("", "please_go_download")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	files, err := fetch(config, mod, tmp)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", mod, err)
+	}
+	if err := verify(config, mod, files); err != nil {
+		return err
+	}
+	if err := checkRetracted(config, mod, files, tmp); err != nil {
+		return err
+	}
+	return extract(mod, files, out, strip, patches)
+}
+
+// checkRetracted warns, or fails if StrictRetractions is set, if the module version has been retracted. Retractions
+// are read from the latest version of the module like the go tool does, falling back to the downloaded go.mod if that
+// isn't available.
+func checkRetracted(config Config, mod module.Version, files *moduleFiles, dir string) error {
+	goModPath, err := latestGoMod(config, mod.Path, dir)
+	if err != nil || goModPath == "" {
+		goModPath = files.GoMod
+	}
+	goMod, err := gomoddeps.ReadGoMod(goModPath, true)
+	if err != nil {
+		return fmt.Errorf("failed to read go.mod for %s: %w", mod, err)
+	}
+	// The module path in the go.mod may not match if this is a fork, so report the one we asked for.
+	goMod.Module = mod.Path
+	if err := goMod.CheckRetracted(mod.Version); err != nil {
+		if config.StrictRetractions {
+			return err
+		}
+		warnings := config.Warnings
+		if warnings == nil {
+			warnings = os.Stderr
+		}
+		fmt.Fprintf(warnings, "warning: %v\n", err)
+	}
+	return nil
+}
+
+// extract unzips a downloaded module into the output directory and applies any modifications to it.
+func extract(mod module.Version, files *moduleFiles, out string, strip, patches []string) error {
+	if err := zip.Unzip(out, mod, files.Zip); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", mod, err)
+	}
+	// The go tool leaves the module read-only (unless -modcacherw is given) but we want to be able to modify it.
+	if err := filepath.WalkDir(out, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Chmod(path, 0644)
+	}); err != nil {
+		return err
+	}
+	// The module zip doesn't always contain a go.mod (e.g. for modules predating them) so use the one we downloaded.
+	goMod, err := os.ReadFile(files.GoMod)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(out, "go.mod"), goMod, 0644); err != nil {
+		return err
+	}
+	for _, s := range strip {
+		if err := os.RemoveAll(filepath.Join(out, s)); err != nil {
+			return err
+		}
+	}
+	for _, patch := range patches {
+		if err := ApplyPatch(out, patch); err != nil {
+			return fmt.Errorf("failed to apply patch %s: %w", patch, err)
+		}
+	}
+	return nil
+}
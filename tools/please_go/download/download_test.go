@@ -0,0 +1,158 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/zip"
+)
+
+var hello = module.Version{Path: "example.com/hello", Version: "v1.0.0"}
+
+// makeProxy creates a file based module proxy containing the test module, returning its URL.
+func makeProxy(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	versionDir := filepath.Join(dir, "example.com/hello/@v")
+	require.NoError(t, os.MkdirAll(versionDir, 0755))
+
+	f, err := os.Create(filepath.Join(versionDir, "v1.0.0.zip"))
+	require.NoError(t, err)
+	require.NoError(t, zip.CreateFromDir(f, hello, "tools/please_go/download/test_data/hello"))
+	require.NoError(t, f.Close())
+
+	goMod, err := os.ReadFile("tools/please_go/download/test_data/hello/go.mod")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(versionDir, "v1.0.0.mod"), goMod, 0644))
+	return "file://" + dir
+}
+
+func TestDownload(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hello")
+	config := Config{GoProxy: makeProxy(t), GoSumDB: "off"}
+	err := Download(config, hello, out, []string{"docs"}, []string{"tools/please_go/download/test_data/hello.patch"})
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(out, "go.mod"))
+	assert.NoDirExists(t, filepath.Join(out, "docs"))
+	assert.FileExists(t, filepath.Join(out, "goodbye.go"))
+	contents, err := os.ReadFile(filepath.Join(out, "hello.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), `return "hello, world"`)
+}
+
+func TestDownloadFallsThroughNotFound(t *testing.T) {
+	proxy := makeProxy(t)
+	config := Config{GoProxy: "file://" + t.TempDir() + "," + proxy, GoSumDB: "off"}
+	err := Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil)
+	require.NoError(t, err)
+}
+
+func TestDownloadOff(t *testing.T) {
+	config := Config{GoProxy: "off", GoSumDB: "off"}
+	err := Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil)
+	assert.ErrorContains(t, err, "GOPROXY=off")
+}
+
+func TestDownloadVerifiesGoSum(t *testing.T) {
+	proxy := makeProxy(t)
+	files, err := fetchFromProxy(proxy, hello, t.TempDir(), nil)
+	require.NoError(t, err)
+	zipHash, modHash, err := hashModule(files)
+	require.NoError(t, err)
+	goSum := filepath.Join(t.TempDir(), "go.sum")
+	config := Config{GoProxy: proxy, GoSumDB: "off", GoSum: goSum}
+
+	t.Run("Match", func(t *testing.T) {
+		writeGoSum(t, goSum, zipHash, modHash)
+		assert.NoError(t, Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil))
+	})
+	t.Run("Mismatch", func(t *testing.T) {
+		writeGoSum(t, goSum, "h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", modHash)
+		err := Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil)
+		assert.ErrorContains(t, err, "checksum mismatch")
+	})
+	t.Run("Missing", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(goSum, []byte("example.com/other v1.0.0 "+zipHash+"\n"), 0644))
+		err := Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil)
+		assert.ErrorContains(t, err, "missing checksum for example.com/hello@v1.0.0")
+	})
+}
+
+func writeGoSum(t *testing.T, path, zipHash, modHash string) {
+	t.Helper()
+	contents := fmt.Sprintf("%s %s %s\n%s %s/go.mod %s\n", hello.Path, hello.Version, zipHash, hello.Path, hello.Version, modHash)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+}
+
+func TestDownloadRetracted(t *testing.T) {
+	proxy := makeProxy(t)
+	// Publish a later version that retracts the one we want
+	versionDir := filepath.Join(strings.TrimPrefix(proxy, "file://"), "example.com/hello/@v")
+	require.NoError(t, os.WriteFile(filepath.Join(versionDir, "v1.1.0.mod"), []byte("module example.com/hello\n\nretract v1.0.0 // Broken greeting\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(versionDir, "../@latest"), []byte(`{"Version":"v1.1.0"}`), 0644))
+
+	t.Run("Warns", func(t *testing.T) {
+		var warnings bytes.Buffer
+		config := Config{GoProxy: proxy, GoSumDB: "off", Warnings: &warnings}
+		assert.NoError(t, Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil))
+		assert.Equal(t, "warning: example.com/hello@v1.0.0 has been retracted: Broken greeting\n", warnings.String())
+	})
+	t.Run("StrictRetractions", func(t *testing.T) {
+		config := Config{GoProxy: proxy, GoSumDB: "off", StrictRetractions: true}
+		err := Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil)
+		assert.ErrorContains(t, err, "example.com/hello@v1.0.0 has been retracted: Broken greeting")
+	})
+}
+
+func TestDownloadValidate(t *testing.T) {
+	proxy := makeProxy(t)
+	modFile := filepath.Join(t.TempDir(), "go.mod")
+	require.NoError(t, os.WriteFile(modFile, []byte("module example.com/host\n\nexclude example.com/hello v1.0.0\n"), 0644))
+	config := Config{GoProxy: proxy, GoSumDB: "off", Validate: true, ModFile: modFile}
+	err := Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil)
+	assert.ErrorContains(t, err, "example.com/hello@v1.0.0 is excluded by")
+}
+
+func TestDownloadAuthenticatesWithNetrc(t *testing.T) {
+	proxyDir := strings.TrimPrefix(makeProxy(t), "file://")
+	fileServer := http.FileServer(http.Dir(proxyDir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "please" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	t.Run("Authenticated", func(t *testing.T) {
+		netrc := filepath.Join(t.TempDir(), ".netrc")
+		require.NoError(t, os.WriteFile(netrc, []byte("machine "+u.Hostname()+"\n  login please\n  password secret\n"), 0600))
+		config := Config{GoProxy: server.URL, GoSumDB: "off", Netrc: netrc}
+		assert.NoError(t, Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil))
+	})
+	t.Run("Unauthenticated", func(t *testing.T) {
+		config := Config{GoProxy: server.URL, GoSumDB: "off"}
+		err := Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil)
+		assert.ErrorContains(t, err, "401 Unauthorized")
+	})
+}
+
+func TestDownloadSkipsSumDBForPrivateModules(t *testing.T) {
+	// The checksum database would otherwise be consulted, which would fail as it doesn't know about the module
+	config := Config{GoProxy: makeProxy(t), GoSumDB: "sum.golang.org", GoNoSumDB: "example.com/*"}
+	assert.NoError(t, Download(config, hello, filepath.Join(t.TempDir(), "hello"), nil, nil))
+}
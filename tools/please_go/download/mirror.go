@@ -0,0 +1,134 @@
+package download
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Mirror downloads the given modules into a directory with the GOPROXY layout, which can then be used as a module proxy
+// via a file:// URL. Modules are verified in the same way as they are by Download, and the mirror can already contain
+// other modules, in which case the version lists are merged.
+func Mirror(config Config, mods []module.Version, out string) error {
+	versions := map[string][]string{}
+	for _, mod := range mods {
+		if err := mirrorModule(config, mod, out); err != nil {
+			return err
+		}
+		versions[mod.Path] = append(versions[mod.Path], mod.Version)
+	}
+	for path, vs := range versions {
+		if err := writeVersionList(out, path, vs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorModule downloads a single module into the mirror as its .mod, .zip and .info files
+func mirrorModule(config Config, mod module.Version, out string) error {
+	if err := module.Check(mod.Path, mod.Version); err != nil {
+		return err
+	}
+	dir, err := versionDir(out, mod.Path)
+	if err != nil {
+		return err
+	}
+	version, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "please_go_mirror")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	files, err := fetch(config, mod, tmp)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", mod, err)
+	}
+	if err := verify(config, mod, files); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := copyFile(files.GoMod, filepath.Join(dir, version+".mod")); err != nil {
+		return err
+	}
+	if err := copyFile(files.Zip, filepath.Join(dir, version+".zip")); err != nil {
+		return err
+	}
+	// We don't know when the version was published, so only record what it is. The go tool doesn't need any more.
+	info, err := json.Marshal(struct{ Version string }{Version: mod.Version})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, version+".info"), info, 0644)
+}
+
+// writeVersionList writes the @v/list file for a module, including any versions that were already listed there.
+func writeVersionList(out, path string, versions []string) error {
+	dir, err := versionDir(out, path)
+	if err != nil {
+		return err
+	}
+	listFile := filepath.Join(dir, "list")
+	if f, err := os.Open(listFile); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				versions = append(versions, line)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	semver.Sort(versions)
+	var list strings.Builder
+	for i, version := range versions {
+		// The GOPROXY protocol leaves pseudo-versions out of the list, although they can still be fetched.
+		if (i == 0 || version != versions[i-1]) && !module.IsPseudoVersion(version) {
+			list.WriteString(version + "\n")
+		}
+	}
+	return os.WriteFile(listFile, []byte(list.String()), 0644)
+}
+
+// versionDir returns the @v directory for a module in the mirror
+func versionDir(out, path string) (string, error) {
+	escaped, err := module.EscapePath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(out, filepath.FromSlash(escaped), "@v"), nil
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Close()
+}
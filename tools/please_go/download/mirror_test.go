@@ -0,0 +1,43 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
+)
+
+func TestMirror(t *testing.T) {
+	out := t.TempDir()
+	versionDir := filepath.Join(out, "example.com/hello/@v")
+	// The mirror already has other versions of the module, including a pseudo-version which isn't listed
+	require.NoError(t, os.MkdirAll(versionDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(versionDir, "list"), []byte("v1.1.0\nv0.9.0\nv0.0.0-20200101000000-abcdefabcdef\n"), 0644))
+
+	config := Config{GoProxy: makeProxy(t), GoSumDB: "off"}
+	require.NoError(t, Mirror(config, []module.Version{hello}, out))
+
+	assert.FileExists(t, filepath.Join(versionDir, "v1.0.0.zip"))
+	assert.FileExists(t, filepath.Join(versionDir, "v1.0.0.mod"))
+	info, err := os.ReadFile(filepath.Join(versionDir, "v1.0.0.info"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Version":"v1.0.0"}`, string(info))
+	list, err := os.ReadFile(filepath.Join(versionDir, "list"))
+	require.NoError(t, err)
+	assert.Equal(t, "v0.9.0\nv1.0.0\nv1.1.0\n", string(list))
+
+	// Modules can then be downloaded from the mirror rather than the original proxy
+	config = Config{GoProxy: "file://" + out, GoSumDB: "off"}
+	dir := filepath.Join(t.TempDir(), "hello")
+	require.NoError(t, Download(config, hello, dir, nil, nil))
+	assert.FileExists(t, filepath.Join(dir, "hello.go"))
+}
+
+func TestMirrorEscapesPaths(t *testing.T) {
+	dir, err := versionDir("mirror", "github.com/Azure/azure-sdk-for-go")
+	require.NoError(t, err)
+	assert.Equal(t, "mirror/github.com/!azure/azure-sdk-for-go/@v", dir)
+}
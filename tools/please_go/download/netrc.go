@@ -0,0 +1,73 @@
+package download
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// netrc holds the credentials from a .netrc file, which authenticate requests to module proxies like the go tool does.
+type netrc map[string]netrcLogin
+
+type netrcLogin struct {
+	login    string
+	password string
+}
+
+// readNetrc reads a .netrc file. There are no credentials if the path is empty.
+func readNetrc(path string) (netrc, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNetrc(string(data)), nil
+}
+
+// parseNetrc parses the machine entries of a .netrc file. As with the go tool, the first entry for a machine wins and
+// anything from a default entry onwards is ignored.
+func parseNetrc(data string) netrc {
+	n := netrc{}
+	machine := ""
+	login := netrcLogin{}
+	add := func() {
+		if _, present := n[machine]; machine != "" && !present {
+			n[machine] = login
+		}
+	}
+	tokens := strings.Fields(data)
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine", "default":
+			add()
+			machine, login = "", netrcLogin{}
+			if tokens[i] == "default" {
+				return n
+			} else if i+1 < len(tokens) {
+				i++
+				machine = tokens[i]
+			}
+		case "login":
+			if i+1 < len(tokens) {
+				i++
+				login.login = tokens[i]
+			}
+		case "password":
+			if i+1 < len(tokens) {
+				i++
+				login.password = tokens[i]
+			}
+		}
+	}
+	add()
+	return n
+}
+
+// authenticate adds the credentials for the request's host to it, if there are any.
+func (n netrc) authenticate(req *http.Request) {
+	if login, present := n[req.URL.Hostname()]; present {
+		req.SetBasicAuth(login.login, login.password)
+	}
+}
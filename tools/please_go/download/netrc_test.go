@@ -0,0 +1,25 @@
+package download
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetrc(t *testing.T) {
+	n := parseNetrc(`
+machine proxy.example.com
+  login alice
+  password hunter2
+
+machine git.example.com login bob password swordfish
+machine proxy.example.com login mallory password evil
+
+default login anonymous password guest
+machine ignored.example.com login carol password secret
+`)
+	assert.Equal(t, netrc{
+		"proxy.example.com": {login: "alice", password: "hunter2"},
+		"git.example.com":   {login: "bob", password: "swordfish"},
+	}, n)
+}
@@ -0,0 +1,234 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A filePatch is the set of changes to a single file in a unified diff
+type filePatch struct {
+	oldName, newName string
+	hunks            []*hunk
+}
+
+// A hunk is a single contiguous change within a file
+type hunk struct {
+	oldStart     int
+	old, new     []string
+	noNewlineOld bool
+	noNewlineNew bool
+}
+
+// ApplyPatch applies a unified diff to the files in the given directory, as `patch -p1` would.
+func ApplyPatch(dir, patchFile string) error {
+	contents, err := os.ReadFile(patchFile)
+	if err != nil {
+		return err
+	}
+	patches, err := parsePatch(string(contents))
+	if err != nil {
+		return err
+	}
+	for _, patch := range patches {
+		if err := patch.apply(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePatch parses a unified diff. Anything outside of the file headers and hunks (e.g. git's extended headers) is
+// ignored.
+func parsePatch(contents string) ([]*filePatch, error) {
+	lines := strings.SplitAfter(contents, "\n")
+	var patches []*filePatch
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		patch := &filePatch{
+			oldName: patchFileName(lines[i]),
+			newName: patchFileName(lines[i+1]),
+		}
+		i += 2
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+			h, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", patch.newName, err)
+			}
+			patch.hunks = append(patch.hunks, h)
+			i += n
+		}
+		patches = append(patches, patch)
+		i--
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no changes found in patch")
+	}
+	return patches, nil
+}
+
+// patchFileName returns the name of the file from a ---/+++ line, with the first path component stripped.
+func patchFileName(line string) string {
+	name := strings.TrimRight(line[4:], "\r\n")
+	// Timestamps are separated from the file name by a tab
+	name, _, _ = strings.Cut(name, "\t")
+	if name == "/dev/null" {
+		return ""
+	}
+	if _, rest, found := strings.Cut(name, "/"); found {
+		return rest
+	}
+	return name
+}
+
+// parseHunk parses a single hunk, returning it and the number of lines it spans.
+func parseHunk(lines []string) (*hunk, int, error) {
+	header := strings.TrimRight(lines[0], "\r\n")
+	ranges := strings.Fields(strings.TrimPrefix(header, "@@ "))
+	if len(ranges) < 2 || !strings.HasPrefix(ranges[0], "-") || !strings.HasPrefix(ranges[1], "+") {
+		return nil, 0, fmt.Errorf("invalid hunk header %q", header)
+	}
+	oldStart, oldLen, err := parseRange(ranges[0][1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	_, newLen, err := parseRange(ranges[1][1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	h := &hunk{oldStart: oldStart}
+	i := 1
+	for ; i < len(lines) && (len(h.old) < oldLen || len(h.new) < newLen || strings.HasPrefix(lines[i], `\`)); i++ {
+		line := lines[i]
+		if line == "" {
+			break
+		}
+		switch line[0] {
+		case ' ':
+			h.old = append(h.old, line[1:])
+			h.new = append(h.new, line[1:])
+		case '\n':
+			// Some editors strip the trailing space from empty context lines
+			h.old = append(h.old, line)
+			h.new = append(h.new, line)
+		case '-':
+			h.old = append(h.old, line[1:])
+		case '+':
+			h.new = append(h.new, line[1:])
+		case '\\':
+			// "\ No newline at end of file" applies to whichever line came immediately before it
+			switch lines[i-1][0] {
+			case '-':
+				h.noNewlineOld = true
+			case '+':
+				h.noNewlineNew = true
+			default:
+				h.noNewlineOld = true
+				h.noNewlineNew = true
+			}
+		default:
+			return nil, 0, fmt.Errorf("invalid line in hunk: %q", line)
+		}
+	}
+	if len(h.old) != oldLen || len(h.new) != newLen {
+		return nil, 0, fmt.Errorf("hunk %q is truncated", header)
+	}
+	if h.noNewlineOld && len(h.old) > 0 {
+		h.old[len(h.old)-1] = strings.TrimSuffix(h.old[len(h.old)-1], "\n")
+	}
+	if h.noNewlineNew && len(h.new) > 0 {
+		h.new[len(h.new)-1] = strings.TrimSuffix(h.new[len(h.new)-1], "\n")
+	}
+	return h, i, nil
+}
+
+// parseRange parses a range from a hunk header, e.g. 12,3. The length defaults to 1 if not given.
+func parseRange(r string) (int, int, error) {
+	start, length, found := strings.Cut(r, ",")
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid hunk range %q", r)
+	}
+	if !found {
+		return s, 1, nil
+	}
+	l, err := strconv.Atoi(length)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid hunk range %q", r)
+	}
+	return s, l, nil
+}
+
+// apply applies the patch to the relevant file in the directory
+func (patch *filePatch) apply(dir string) error {
+	if patch.newName == "" {
+		return os.Remove(filepath.Join(dir, patch.oldName))
+	}
+	var lines []string
+	if patch.oldName != "" {
+		contents, err := os.ReadFile(filepath.Join(dir, patch.oldName))
+		if err != nil {
+			return err
+		}
+		lines = strings.SplitAfter(string(contents), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+	}
+	// Track how far the file has moved relative to the line numbers in the patch as hunks are applied.
+	offset := 0
+	for _, h := range patch.hunks {
+		start := h.oldStart - 1 + offset
+		if len(h.old) == 0 {
+			// Pure insertions give the line after which to insert, rather than the first line affected
+			start++
+		}
+		pos := findHunk(lines, h.old, start)
+		if pos == -1 {
+			return fmt.Errorf("hunk at line %d does not apply to %s", h.oldStart, patch.newName)
+		}
+		lines = append(lines[:pos], append(append([]string{}, h.new...), lines[pos+len(h.old):]...)...)
+		offset += pos - start + len(h.new) - len(h.old)
+	}
+	path := filepath.Join(dir, patch.newName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0644); err != nil {
+		return err
+	}
+	if patch.oldName != "" && patch.oldName != patch.newName {
+		return os.Remove(filepath.Join(dir, patch.oldName))
+	}
+	return nil
+}
+
+// findHunk returns the position in the file that the hunk's old lines appear closest to the given line, or -1 if
+// they don't appear at all.
+func findHunk(lines, old []string, start int) int {
+	for delta := 0; delta <= len(lines); delta++ {
+		if pos := start - delta; pos >= 0 && matchesAt(lines, old, pos) {
+			return pos
+		}
+		if pos := start + delta; delta > 0 && matchesAt(lines, old, pos) {
+			return pos
+		}
+	}
+	return -1
+}
+
+func matchesAt(lines, old []string, pos int) bool {
+	if pos < 0 || pos+len(old) > len(lines) {
+		return false
+	}
+	for i, line := range old {
+		if lines[pos+i] != line {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		patch    string
+		expected string
+	}{
+		{
+			name:     "modify",
+			original: "a\nb\nc\n",
+			patch:    "--- a/file.txt\n+++ b/file.txt\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+			expected: "a\nB\nc\n",
+		},
+		{
+			name:     "offset",
+			original: "x\ny\na\nb\nc\n",
+			patch:    "--- a/file.txt\n+++ b/file.txt\n@@ -1,3 +1,4 @@\n a\n b\n+b2\n c\n",
+			expected: "x\ny\na\nb\nb2\nc\n",
+		},
+		{
+			name:     "multiple hunks",
+			original: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			patch:    "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\n 1\n+1.5\n 2\n@@ -7,2 +8,1 @@\n 7\n-8\n",
+			expected: "1\n1.5\n2\n3\n4\n5\n6\n7\n",
+		},
+		{
+			name:     "no newline at end of file",
+			original: "a\nb",
+			patch:    "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n",
+			expected: "a\nc\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte(test.original), 0644))
+			require.NoError(t, applyPatchString(dir, test.patch))
+			contents, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(contents))
+		})
+	}
+}
+
+func TestApplyPatchCreateAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.txt"), []byte("old\n"), 0644))
+	patch := "--- a/old.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n" +
+		"--- /dev/null\n+++ b/sub/new.txt\n@@ -0,0 +1,2 @@\n+new\n+file\n"
+	require.NoError(t, applyPatchString(dir, patch))
+
+	assert.NoFileExists(t, filepath.Join(dir, "old.txt"))
+	contents, err := os.ReadFile(filepath.Join(dir, "sub/new.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new\nfile\n", string(contents))
+}
+
+func TestApplyPatchConflict(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("a\nb\n"), 0644))
+	err := applyPatchString(dir, "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,2 @@\n a\n-c\n+d\n")
+	assert.ErrorContains(t, err, "does not apply")
+}
+
+func applyPatchString(dir, patch string) error {
+	patchFile := filepath.Join(dir, "test.patch")
+	if err := os.WriteFile(patchFile, []byte(patch), 0644); err != nil {
+		return err
+	}
+	return ApplyPatch(dir, patchFile)
+}
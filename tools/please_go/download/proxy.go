@@ -0,0 +1,251 @@
+package download
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// httpClient is used for all requests to module proxies and checksum databases. The timeout stops a stalled server
+// hanging the build forever, and is long enough to download the largest module zips the go tool allows.
+var httpClient = &http.Client{Timeout: 10 * time.Minute}
+
+// moduleFiles are the paths to the files that make up a downloaded module
+type moduleFiles struct {
+	Zip   string
+	GoMod string
+}
+
+// fetch downloads the module's zip and go.mod into the given directory, trying each proxy in turn as the go tool does.
+func fetch(config Config, mod module.Version, dir string) (*moduleFiles, error) {
+	if module.MatchPrefixPatterns(config.GoNoProxy, mod.Path) {
+		return fetchDirect(config, mod, dir)
+	}
+	proxies := parseProxies(config.GoProxy)
+	if len(proxies) == 0 {
+		return nil, errors.New("no module proxy configured (GOPROXY is empty)")
+	}
+	auth, err := readNetrc(config.Netrc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read netrc: %w", err)
+	}
+	var lastErr error
+	for _, proxy := range proxies {
+		var files *moduleFiles
+		var err error
+		switch proxy.url {
+		case "off":
+			return nil, fmt.Errorf("module lookup disabled by GOPROXY=off")
+		case "direct":
+			files, err = fetchDirect(config, mod, dir)
+		default:
+			files, err = fetchFromProxy(proxy.url, mod, dir, auth)
+		}
+		if err == nil {
+			return files, nil
+		} else if !proxy.fallthroughAll && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// A proxy is one entry in GOPROXY
+type proxy struct {
+	url string
+	// fallthroughAll is true if any error from this proxy should fall through to the next one, rather than just not
+	// found errors
+	fallthroughAll bool
+}
+
+// parseProxies parses a GOPROXY list. Proxies separated by a comma only fall through to the next on a not found error;
+// a pipe falls through on any error.
+func parseProxies(goproxy string) []proxy {
+	var proxies []proxy
+	for goproxy != "" {
+		i := strings.IndexAny(goproxy, ",|")
+		p := proxy{url: goproxy}
+		if i == -1 {
+			goproxy = ""
+		} else {
+			p.url, p.fallthroughAll, goproxy = goproxy[:i], goproxy[i] == '|', goproxy[i+1:]
+		}
+		if p.url = strings.TrimSpace(p.url); p.url != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// latestGoMod fetches the go.mod of the latest version of the module from the first proxy, which is where the go tool
+// reads retractions from. It returns an empty path if the module isn't fetched via a proxy.
+func latestGoMod(config Config, modPath, dir string) (string, error) {
+	proxies := parseProxies(config.GoProxy)
+	if len(proxies) == 0 || proxies[0].url == "direct" || proxies[0].url == "off" || module.MatchPrefixPatterns(config.GoNoProxy, modPath) {
+		return "", nil
+	}
+	path, err := module.EscapePath(modPath)
+	if err != nil {
+		return "", err
+	}
+	auth, err := readNetrc(config.Netrc)
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(proxies[0].url, "/") + "/" + path + "/@"
+	latestFile := filepath.Join(dir, "latest.json")
+	if err := get(base+"latest", latestFile, auth); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(latestFile)
+	if err != nil {
+		return "", err
+	}
+	latest := struct{ Version string }{}
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return "", err
+	}
+	version, err := module.EscapeVersion(latest.Version)
+	if err != nil {
+		return "", err
+	}
+	goMod := filepath.Join(dir, "latest.mod")
+	return goMod, get(base+"v/"+version+".mod", goMod, auth)
+}
+
+// fetchFromProxy downloads the module from a single proxy using the GOPROXY protocol
+func fetchFromProxy(proxy string, mod module.Version, dir string, auth netrc) (*moduleFiles, error) {
+	path, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return nil, err
+	}
+	version, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(proxy, "/") + "/" + path + "/@v/" + version
+	files := &moduleFiles{
+		Zip:   filepath.Join(dir, "module.zip"),
+		GoMod: filepath.Join(dir, "go.mod"),
+	}
+	if err := get(base+".mod", files.GoMod, auth); err != nil {
+		return nil, err
+	}
+	if err := get(base+".zip", files.Zip, auth); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// get fetches a URL to the given file, authenticating with any credentials for its host. Not found errors wrap
+// fs.ErrNotExist.
+func get(rawURL, out string, auth netrc) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	var body io.ReadCloser
+	switch u.Scheme {
+	case "file":
+		f, err := os.Open(filepath.FromSlash(u.Path))
+		if err != nil {
+			return err
+		}
+		body = f
+	case "http", "https":
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return err
+		}
+		auth.authenticate(req)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			resp.Body.Close()
+			return fmt.Errorf("%s: %s: %w", rawURL, resp.Status, fs.ErrNotExist)
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("%s: %s", rawURL, resp.Status)
+		}
+		body = resp.Body
+	default:
+		return fmt.Errorf("unsupported module proxy URL %s", rawURL)
+	}
+	defer body.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, body); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// fetchDirect downloads the module straight from its version control system. We leave that to the go tool, which
+// knows how to resolve module paths to repositories.
+func fetchDirect(config Config, mod module.Version, dir string) (*moduleFiles, error) {
+	workDir := filepath.Join(dir, "direct")
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, err
+	}
+	// Stops the go tool from finding any go.mod above us and downloading all of that too
+	if err := os.WriteFile(filepath.Join(workDir, "go.mod"), []byte("module please_go_download\n"), 0644); err != nil {
+		return nil, err
+	}
+	goTool := config.GoTool
+	if goTool == "" {
+		goTool = "go"
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(goTool, "mod", "download", "-modcacherw", "-json", mod.String())
+	cmd.Dir = workDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// We verify the module ourselves so there's no need for the go tool to do it too. GOFLAGS is cleared so that flags
+	// meant for builds, like -mod=vendor, can't break the download.
+	cmd.Env = append(os.Environ(), "GOPATH="+filepath.Join(dir, "gopath"), "GOPROXY=direct", "GOSUMDB=off", "GOFLAGS=")
+	if config.Netrc != "" {
+		netrc, err := filepath.Abs(config.Netrc)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Env = append(cmd.Env, "NETRC="+netrc)
+	}
+	runErr := cmd.Run()
+
+	result := struct {
+		Zip   string
+		GoMod string
+		Error string
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("go mod download failed: %w\n%s", runErr, stderr)
+		}
+		return nil, fmt.Errorf("failed to parse output of go mod download: %w", err)
+	} else if result.Error != "" {
+		return nil, errors.New(result.Error)
+	} else if runErr != nil {
+		return nil, fmt.Errorf("go mod download failed: %w\n%s", runErr, stderr)
+	}
+	return &moduleFiles{Zip: result.Zip, GoMod: result.GoMod}, nil
+}
@@ -0,0 +1,221 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// knownSumDBs are the verifier keys for checksum databases that can be referred to by name alone.
+var knownSumDBs = map[string]string{
+	"sum.golang.org": "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8",
+}
+
+// sumDBAliases are names for known checksum databases that are served from a different URL, as GOSUMDB values.
+var sumDBAliases = map[string]string{
+	"sum.golang.google.cn": "sum.golang.org https://sum.golang.google.cn",
+}
+
+// verify checks the hashes of the downloaded module against go.sum if we were given one, otherwise against the checksum
+// database.
+func verify(config Config, mod module.Version, files *moduleFiles) error {
+	zipHash, modHash, err := hashModule(files)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", mod, err)
+	}
+
+	if config.GoSum != "" {
+		lines, err := readGoSum(config.GoSum, mod)
+		if err != nil {
+			return err
+		}
+		if err := checkHashes(mod, lines, zipHash, modHash, config.GoSum); errors.Is(err, errMissingHash) {
+			return fmt.Errorf("%w: run `go mod download %s` to add it", err, mod)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+	if config.GoSumDB == "off" || module.MatchPrefixPatterns(config.GoNoSumDB, mod.Path) {
+		return nil
+	}
+	client, name, err := newSumDBClient(config.GoSumDB)
+	if err != nil {
+		return err
+	}
+	lines, err := client.Lookup(mod.Path, mod.Version)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s in checksum database %s: %w", mod, name, err)
+	}
+	modLines, err := client.Lookup(mod.Path, mod.Version+"/go.mod")
+	if err != nil {
+		return fmt.Errorf("failed to look up %s/go.mod in checksum database %s: %w", mod, name, err)
+	}
+	return checkHashes(mod, append(lines, modLines...), zipHash, modHash, name)
+}
+
+// hashModule returns the go.sum hashes of the module's zip and go.mod
+func hashModule(files *moduleFiles) (string, string, error) {
+	zipHash, err := dirhash.HashZip(files.Zip, dirhash.Hash1)
+	if err != nil {
+		return "", "", err
+	}
+	modHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return os.Open(files.GoMod)
+	})
+	return zipHash, modHash, err
+}
+
+// readGoSum returns the lines of a go.sum file that refer to the given module
+func readGoSum(path string, mod module.Version) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 3 && fields[0] == mod.Path {
+			if fields[1] == mod.Version || fields[1] == mod.Version+"/go.mod" {
+				lines = append(lines, scanner.Text())
+			}
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// errMissingHash is returned when there's no hash to verify a module against
+var errMissingHash = errors.New("missing checksum")
+
+// checkHashes checks the hashes of a module against the given go.sum style lines. Both the module's zip and its go.mod
+// must have a hash present.
+func checkHashes(mod module.Version, lines []string, zipHash, modHash, source string) error {
+	expected := map[string]string{}
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) == 3 && fields[0] == mod.Path {
+			expected[fields[1]] = fields[2]
+		}
+	}
+	for _, file := range []struct{ version, hash string }{
+		{version: mod.Version, hash: zipHash},
+		{version: mod.Version + "/go.mod", hash: modHash},
+	} {
+		if want, present := expected[file.version]; !present {
+			return fmt.Errorf("%w for %s@%s in %s", errMissingHash, mod.Path, file.version, source)
+		} else if want != file.hash {
+			return fmt.Errorf("checksum mismatch for %s@%s: downloaded %s but %s has %s", mod.Path, file.version, file.hash, source, want)
+		}
+	}
+	return nil
+}
+
+// newSumDBClient returns a client for the checksum database described by the given GOSUMDB value, along with its name.
+func newSumDBClient(gosumdb string) (*sumdb.Client, string, error) {
+	ops, name, err := newSumDBOps(gosumdb)
+	if err != nil {
+		return nil, "", err
+	}
+	return sumdb.NewClient(ops), name, nil
+}
+
+// newSumDBOps parses a GOSUMDB value into the operations for a client of that checksum database, and its name.
+func newSumDBOps(gosumdb string) (*sumDBOps, string, error) {
+	if alias, present := sumDBAliases[gosumdb]; present {
+		gosumdb = alias
+	}
+	fields := strings.Fields(gosumdb)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, "", fmt.Errorf("invalid GOSUMDB: %s", gosumdb)
+	}
+	key := fields[0]
+	if known, present := knownSumDBs[key]; present {
+		key = known
+	}
+	name, _, _ := strings.Cut(key, "+")
+	url := "https://" + name
+	if len(fields) == 2 {
+		url = fields[1]
+	}
+	ops := &sumDBOps{
+		url:    strings.TrimSuffix(url, "/"),
+		key:    key,
+		config: map[string][]byte{},
+		cache:  map[string][]byte{},
+	}
+	return ops, name, nil
+}
+
+// sumDBOps implements sumdb.ClientOps. We don't keep any state between runs so everything is held in memory.
+type sumDBOps struct {
+	url, key string
+	mutex    sync.Mutex
+	config   map[string][]byte
+	cache    map[string][]byte
+}
+
+func (ops *sumDBOps) ReadRemote(path string) ([]byte, error) {
+	resp, err := httpClient.Get(ops.url + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s%s: %s", ops.url, path, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (ops *sumDBOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(ops.key), nil
+	}
+	ops.mutex.Lock()
+	defer ops.mutex.Unlock()
+	return ops.config[file], nil
+}
+
+func (ops *sumDBOps) WriteConfig(file string, old, new []byte) error {
+	ops.mutex.Lock()
+	defer ops.mutex.Unlock()
+	if !bytes.Equal(ops.config[file], old) {
+		return sumdb.ErrWriteConflict
+	}
+	ops.config[file] = new
+	return nil
+}
+
+func (ops *sumDBOps) ReadCache(file string) ([]byte, error) {
+	ops.mutex.Lock()
+	defer ops.mutex.Unlock()
+	if data, present := ops.cache[file]; present {
+		return data, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (ops *sumDBOps) WriteCache(file string, data []byte) {
+	ops.mutex.Lock()
+	defer ops.mutex.Unlock()
+	ops.cache[file] = data
+}
+
+func (ops *sumDBOps) Log(string) {}
+
+// SecurityError is called when the database misbehaves. The client also returns sumdb.ErrSecurity from the lookup, so
+// we only need to log the detail here.
+func (ops *sumDBOps) SecurityError(msg string) {
+	log.Printf("checksum database security error: %s", msg)
+}
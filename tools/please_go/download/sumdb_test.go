@@ -0,0 +1,29 @@
+package download
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSumDBOps(t *testing.T) {
+	for _, tc := range []struct {
+		gosumdb, name, url, key string
+	}{
+		{"sum.golang.org", "sum.golang.org", "https://sum.golang.org", knownSumDBs["sum.golang.org"]},
+		{"sum.golang.google.cn", "sum.golang.org", "https://sum.golang.google.cn", knownSumDBs["sum.golang.org"]},
+		{"sum.golang.org https://proxy.example.com/sumdb/sum.golang.org/", "sum.golang.org", "https://proxy.example.com/sumdb/sum.golang.org", knownSumDBs["sum.golang.org"]},
+		{"example.com+abcd1234+key", "example.com", "https://example.com", "example.com+abcd1234+key"},
+	} {
+		t.Run(tc.gosumdb, func(t *testing.T) {
+			ops, name, err := newSumDBOps(tc.gosumdb)
+			require.NoError(t, err)
+			assert.Equal(t, tc.name, name)
+			assert.Equal(t, tc.url, ops.url)
+			assert.Equal(t, tc.key, ops.key)
+		})
+	}
+	_, _, err := newSumDBOps("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,7 @@
+// Package hello is a test module for the downloader.
+package hello
+
+// Greeting returns a greeting.
+func Greeting() string {
+	return "hello"
+}
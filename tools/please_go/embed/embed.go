@@ -54,7 +54,7 @@ func Parse(gofiles []string) (*Cfg, error) {
 // AddPackage parses a go package and adds any embed patterns to the configuration
 func (cfg *Cfg) AddPackage(pkg *build.Package) error {
 	for _, pattern := range append(append(pkg.EmbedPatterns, pkg.TestEmbedPatterns...), pkg.XTestEmbedPatterns...) {
-		paths, err := relglob(pkg.Dir, pattern)
+		paths, err := Glob(pkg.Dir, pattern)
 		if err != nil {
 			return err
 		}
@@ -78,7 +78,9 @@ func dirs(files []string) []string {
 	return dirs
 }
 
-func relglob(dir, pattern string) ([]string, error) {
+// Glob returns the files matched by a //go:embed pattern in the given directory, relative to it. Directories are
+// matched recursively, excluding hidden files unless the pattern has the all: prefix.
+func Glob(dir, pattern string) ([]string, error) {
 	// Go allows prefixing the pattern with all: which picks up files prefixed with . or _ (by default these should be ignored)
 	includeHidden := false
 	if strings.HasPrefix(pattern, "all:") {
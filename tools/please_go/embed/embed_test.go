@@ -20,3 +20,12 @@ func TestParseEmbed(t *testing.T) {
 		"files/test.txt": "tools/please_go/embed/test_data/files/test.txt",
 	}, cfg.Files)
 }
+
+func TestGlob(t *testing.T) {
+	paths, err := Glob("tools/please_go/embed/test_data", "files")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"files/test.txt"}, paths)
+
+	_, err = Glob("tools/please_go/embed/test_data", "missing/*.txt")
+	assert.Error(t, err)
+}
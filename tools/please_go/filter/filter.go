@@ -3,13 +3,17 @@ package filter
 import (
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
 
-func Filter(tags []string, srcs []string) {
+// Filter prints the sources that match the build constraints. If xtest is "exclude" or "only", files in an external test
+// package are excluded or are the only ones printed, so the test packages can be compiled separately.
+func Filter(tags []string, xtest string, srcs []string) {
 	ctxt := build.Default
 	ctxt.BuildTags = tags
 
@@ -29,8 +33,30 @@ func Filter(tags []string, srcs []string) {
 			os.Exit(1)
 		}
 
+		if ok && xtest != "" {
+			isXTest, err := isExternalTest(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error checking %v: %v\n", f, err)
+				os.Exit(1)
+			}
+			ok = isXTest == (xtest == "only")
+		}
+
 		if ok {
 			fmt.Println(f)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// isExternalTest returns true if the file is in an external test package, i.e. it's a _test.go file in a package whose
+// name ends in _test.
+func isExternalTest(filename string) (bool, error) {
+	if !strings.HasSuffix(filename, "_test.go") {
+		return false, nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasSuffix(f.Name.Name, "_test"), nil
+}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
 	"io/fs"
 	"log"
 	"os"
@@ -14,8 +16,12 @@ import (
 
 	bazelbuild "github.com/bazelbuild/buildtools/build"
 	bazeledit "github.com/bazelbuild/buildtools/edit"
+	"golang.org/x/mod/module"
 
+	"github.com/please-build/go-rules/tools/please_go/embed"
 	"github.com/please-build/go-rules/tools/please_go/generate/gomoddeps"
+	"github.com/please-build/go-rules/tools/please_go/generate/manifest"
+	"github.com/please-build/go-rules/tools/please_go/licences"
 )
 
 type Generate struct {
@@ -27,16 +33,33 @@ type Generate struct {
 	hostModFile        string
 	buildFileNames     []string
 	moduleDeps         []string
-	replace            map[string]string
+	replace            map[string]gomoddeps.Replace
 	knownImportTargets map[string]string // cache these so we don't end up looping over all the modules for every import
+	binaries           []binary
+	embeds             []embedPkg
+	manifest           *manifest.Manifest
 	thirdPartyFolder   string
 	install            []string
 	labels             []string
 	largePackages      []string
 	licences           []string
+	ignoredMains       bool
+	version            string
+	replacement        string
 }
 
-func New(srcRoot, thirdPartyFolder, hostModFile, module, version, subrepo string, buildFileNames, moduleDeps, install, buildTags, labels, largePackages, licences []string) *Generate {
+// binary is a go_binary target generated for a main package.
+type binary struct {
+	name, pkg string
+}
+
+// embedPkg is a package with //go:embed patterns, which are resolved once all the BUILD files have been generated.
+type embedPkg struct {
+	dir, name string
+	patterns  []string
+}
+
+func New(srcRoot, thirdPartyFolder, hostModFile, module, version, replacement, subrepo string, buildFileNames, moduleDeps, install, buildTags, labels, largePackages, licences []string, ignoredMains bool) *Generate {
 	moduleArg := module
 	if version != "" {
 		moduleArg += "@" + version
@@ -56,10 +79,14 @@ func New(srcRoot, thirdPartyFolder, hostModFile, module, version, subrepo string
 		install:            install,
 		moduleName:         module,
 		moduleArg:          moduleArg,
+		manifest:           &manifest.Manifest{Module: module, Version: version, Subrepo: subrepo},
 		subrepo:            subrepo,
 		labels:             labels,
 		largePackages:      largePackages,
 		licences:           licences,
+		ignoredMains:       ignoredMains,
+		version:            version,
+		replacement:        replacement,
 	}
 }
 
@@ -74,8 +101,22 @@ func (g *Generate) Generate() error {
 	// when `Generate` was constructed.
 	g.moduleDeps = append(g.moduleDeps, deps...)
 	g.moduleDeps = append(g.moduleDeps, g.moduleName)
-	g.replace = replacements
+	g.replace = make(map[string]gomoddeps.Replace, len(replacements))
+	for _, replacement := range replacements {
+		g.replace[replacement.Old.Path] = replacement
+	}
+	if g.replacement != "" {
+		path, version, _ := strings.Cut(g.replacement, "@")
+		g.replace[g.moduleName] = gomoddeps.Replace{
+			Old: module.Version{Path: g.moduleName, Version: g.version},
+			New: module.Version{Path: path, Version: version},
+		}
+	}
+	g.setModuleReplacement()
 
+	if err := g.detectLicences(); err != nil {
+		return fmt.Errorf("failed to detect licences: %w", err)
+	}
 	if err := g.writeConfig(); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
@@ -86,7 +127,46 @@ func (g *Generate) Generate() error {
 	if err := g.generateAll(g.srcRoot); err != nil {
 		return fmt.Errorf("failed to generate BUILD files: %w", err)
 	}
-	return g.writeInstallFilegroup()
+	if err := g.resolveEmbeds(); err != nil {
+		return err
+	}
+	if err := g.writeInstallFilegroup(); err != nil {
+		return err
+	}
+	g.manifest.Licences = g.licences
+	if err := g.manifest.Write(g.srcRoot); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// setModuleReplacement records the replacement of the module we're generating rules for, if it was given one or the
+// host go.mod replaces it, so that it's reported in the module info of binaries that use it in the same way the go command does.
+func (g *Generate) setModuleReplacement() {
+	replacement, ok := g.replace[g.moduleName]
+	if !ok || replacement.New.Version == "" {
+		return
+	}
+	old := module.Version{Path: g.moduleName, Version: g.version}
+	if old.Version == "" {
+		old.Version = replacement.Old.Version
+	}
+	g.moduleArg = fmt.Sprintf("%s => %s", old, replacement.New)
+}
+
+// detectLicences uses the module's licence files to fill in its licences if none were given, or warns if the ones given
+// don't match what we find.
+func (g *Generate) detectLicences() error {
+	detected, err := licences.Detect(g.srcRoot)
+	if err != nil {
+		return err
+	}
+	if len(g.licences) == 0 {
+		g.licences = detected
+	} else if msg := licences.Compare(g.licences, detected); msg != "" {
+		log.Printf("warning: %s: %s", g.moduleName, msg)
+	}
+	return nil
 }
 
 // parseImportConfigs walks through the build dir looking for .importconfig files, parsing the # please:target //foo:bar
@@ -138,46 +218,57 @@ func parseImportConfig(path string) (string, []string, error) {
 	return target, imports, nil
 }
 
-func (g *Generate) installTargets() ([]string, error) {
-	var targets []string
+// installTargets returns the targets matching the install patterns. Libraries and binaries are returned separately as
+// binaries are exported as outputs rather than as dependencies.
+func (g *Generate) installTargets() ([]string, []string, error) {
+	var libs, binaries []string
 
 	for _, i := range g.install {
 		dir := filepath.Join(g.srcRoot, i)
 		if strings.HasSuffix(dir, "/...") {
-			ts, err := g.targetsInDir(strings.TrimSuffix(dir, "/..."))
+			ls, bs, err := g.targetsInDir(strings.TrimSuffix(dir, "/..."))
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
-			targets = append(targets, ts...)
+			libs = append(libs, ls...)
+			binaries = append(binaries, bs...)
 		} else {
-			t, err := g.libTargetForBuildPackage(i)
+			t, binary, err := g.installTargetForBuildPackage(i)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			if t == "" {
-				return nil, fmt.Errorf("couldn't find install package %v", i)
+				return nil, nil, fmt.Errorf("couldn't find install package %v", i)
+			}
+			if binary {
+				binaries = append(binaries, t)
+			} else {
+				libs = append(libs, t)
 			}
-			targets = append(targets, t)
 		}
 	}
-	return targets, nil
+	return libs, binaries, nil
 }
 
-func (g *Generate) targetsInDir(dir string) ([]string, error) {
-	var ret []string
+func (g *Generate) targetsInDir(dir string) ([]string, []string, error) {
+	var libs, binaries []string
 	err := filepath.WalkDir(dir, func(path string, info os.DirEntry, err error) error {
 		if g.isBuildFile(path) {
-			t, err := g.libTargetForBuildFile(trimPath(path, g.srcRoot))
+			t, binary, err := g.installTargetForBuildFile(trimPath(path, g.srcRoot))
 			if err != nil {
 				return err
 			}
-			if t != "" {
-				ret = append(ret, t)
+			if t == "" {
+				return nil
+			} else if binary {
+				binaries = append(binaries, t)
+			} else {
+				libs = append(libs, t)
 			}
 		}
 		return nil
 	})
-	return ret, err
+	return libs, binaries, err
 }
 
 func (g *Generate) isBuildFile(file string) bool {
@@ -197,18 +288,66 @@ func (g *Generate) writeInstallFilegroup() error {
 	}
 
 	rule := NewRule("filegroup", "installs")
-	installTargets, err := g.installTargets()
+	libs, binaries, err := g.installTargets()
 	if err != nil {
 		return fmt.Errorf("failed to generate install targets: %v", err)
 	}
-	rule.SetAttr("exported_deps", NewStringList(installTargets))
+	if len(binaries) > 0 {
+		rule.SetAttr("srcs", NewStringList(binaries))
+		if len(binaries) == 1 && len(libs) == 0 {
+			rule.SetAttr("binary", NewBoolExpr(true))
+		}
+	}
+	rule.SetAttr("exported_deps", NewStringList(libs))
 	rule.SetAttr("visibility", NewStringList([]string{"PUBLIC"}))
 
 	buildFile.Stmt = append(buildFile.Stmt, rule.Call)
+	if len(g.binaries) > 0 {
+		buildFile.Stmt = append(buildFile.Stmt, g.binariesRule().Call)
+	}
 
 	return saveBuildFile(buildFile)
 }
 
+// binariesRule returns a rule collecting every main package in the module, with an entry point for each so they can
+// be used as tools e.g. ///third_party/go/google.golang.org_protobuf//:binaries|protoc-gen-go
+func (g *Generate) binariesRule() *bazelbuild.Rule {
+	names := map[string]int{}
+	for _, b := range g.binaries {
+		names[b.name]++
+	}
+	srcs := make([]string, 0, len(g.binaries))
+	cmds := []string{"mkdir -p $OUT"}
+	entryPoints := &bazelbuild.DictExpr{ForceMultiLine: true}
+	for _, b := range g.binaries {
+		target := buildTarget(b.name, b.pkg, "")
+		name := b.name
+		if names[name] > 1 {
+			// Disambiguate binaries of the same name by their package, or the module for its root package, which
+			// would otherwise clash with a package of the same name as the module.
+			if b.pkg == "" || b.pkg == "." {
+				name = strings.ReplaceAll(g.moduleName, "/", "_")
+			} else {
+				name = strings.ReplaceAll(b.pkg, "/", "_")
+			}
+		}
+		srcs = append(srcs, target)
+		cmds = append(cmds, fmt.Sprintf("cp $(location %s) $OUT/%s", target, name))
+		entryPoints.List = append(entryPoints.List, &bazelbuild.KeyValueExpr{
+			Key:   NewStringExpr(name),
+			Value: NewStringExpr("binaries/" + name),
+		})
+	}
+	rule := NewRule("build_rule", "binaries")
+	rule.SetAttr("srcs", NewStringList(srcs))
+	rule.SetAttr("outs", NewStringList([]string{"binaries"}))
+	rule.SetAttr("cmd", NewStringExpr(strings.Join(cmds, " && ")))
+	rule.SetAttr("entry_points", entryPoints)
+	rule.SetAttr("binary", NewBoolExpr(true))
+	rule.SetAttr("visibility", NewStringList([]string{"PUBLIC"}))
+	return rule
+}
+
 func (g *Generate) writeConfig() error {
 	file, err := os.Create(filepath.Join(g.srcRoot, ".plzconfig"))
 	if err != nil {
@@ -261,7 +400,13 @@ func (g *Generate) pkgDir(target string) string {
 func (g *Generate) importDir(target string) (*build.Package, error) {
 	dir := filepath.Join(os.Getenv("TMP_DIR"), g.pkgDir(target))
 	pkg, err := g.buildContext.ImportDir(dir, 0)
-	if err != nil {
+	if multiErr, ok := err.(*build.MultiplePackageError); ok {
+		pkg, err = g.importDirWithClashes(dir, multiErr)
+	}
+	if _, ok := err.(*build.NoGoError); ok && g.ignoredMains {
+		// The directory might still contain generator mains that we've been asked to build.
+		return pkg, err
+	} else if err != nil {
 		return nil, err
 	}
 	// We also need to discover & attach any .a files in the directory; some libraries use these
@@ -278,9 +423,115 @@ func (g *Generate) importDir(target string) (*build.Package, error) {
 	return pkg, nil
 }
 
+// importDirWithClashes handles a directory containing files from more than one package. We pick the package that
+// looks like the real one, warn about the files from any others and then import the directory again without them.
+func (g *Generate) importDirWithClashes(dir string, multiErr *build.MultiplePackageError) (*build.Package, error) {
+	files, err := g.packageFiles(dir)
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, multiErr
+	}
+	name := choosePackage(filepath.Base(dir), files)
+	skipped := map[string]bool{}
+	var skippedNames []string
+	for pkgName, names := range files {
+		if pkgName != name {
+			for _, f := range names {
+				skipped[f] = true
+				skippedNames = append(skippedNames, f)
+			}
+		}
+	}
+	slices.Sort(skippedNames)
+	log.Printf("warning: %v; using package %s and skipping %s", multiErr, name, strings.Join(skippedNames, ", "))
+
+	ctxt := g.buildContext
+	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]fs.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			if skipped[entry.Name()] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+	}
+	return ctxt.ImportDir(dir, 0)
+}
+
+// packageFiles returns the non-test Go files in a directory that match the build context, keyed by package name.
+func (g *Generate) packageFiles(dir string) (map[string][]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if match, err := g.buildContext.MatchFile(dir, name); err != nil {
+			return nil, err
+		} else if !match {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err != nil {
+			return nil, err
+		}
+		if f.Name.Name == "documentation" {
+			continue
+		}
+		files[f.Name.Name] = append(files[f.Name.Name], name)
+	}
+	return files, nil
+}
+
+// choosePackage picks which of the packages in a directory to generate a rule for. We prefer the package named after
+// the directory, then anything that isn't main, and otherwise whichever package has the most files.
+func choosePackage(dirName string, files map[string][]string) string {
+	if _, present := files[dirName]; present {
+		return dirName
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if (a == "main") != (b == "main") {
+			if a == "main" {
+				return 1
+			}
+			return -1
+		} else if n := len(files[b]) - len(files[a]); n != 0 {
+			return n
+		}
+		return strings.Compare(a, b)
+	})
+	return names[0]
+}
+
 func (g *Generate) generate(dir string) error {
 	pkg, err := g.importDir(dir)
-	if err != nil {
+	if noGoErr, ok := err.(*build.NoGoError); ok && pkg != nil {
+		binaries, err := g.ignoredMainRules(pkg, dir)
+		if err != nil {
+			return err
+		} else if len(binaries) == 0 {
+			return noGoErr
+		}
+		return g.createBuildFile(dir, nil, binaries, nil)
+	} else if err != nil {
 		return err
 	}
 
@@ -298,11 +549,197 @@ func (g *Generate) generate(dir string) error {
 
 	pkg.GoFiles = goFiles
 	lib := g.ruleForPackage(pkg, dir)
-	if lib == nil {
+	binaries, err := g.ignoredMainRules(pkg, dir)
+	if err != nil {
+		return err
+	}
+	if lib == nil && len(binaries) == 0 {
 		return nil
 	}
+	if lib != nil {
+		g.manifest.Packages = append(g.manifest.Packages, g.manifestPackage(pkg, dir, lib))
+		if len(pkg.EmbedPatterns) > 0 {
+			g.embeds = append(g.embeds, embedPkg{dir: dir, name: lib.name, patterns: pkg.EmbedPatterns})
+		}
+		if lib.isCMD {
+			g.binaries = append(g.binaries, binary{name: lib.name, pkg: dir})
+		}
+	}
+
+	return g.createBuildFile(dir, lib, binaries, pkg.IgnoredOtherFiles)
+}
 
-	return g.createBuildFile(dir, lib, pkg.IgnoredOtherFiles)
+// resolveEmbeds sets the resources of each rule with embed patterns to the exact files those patterns match. We can't
+// use a glob because embed patterns match directories recursively and can reach into subdirectories which are packages
+// of their own; files in those are exported to the embedding rule by a filegroup in that package instead.
+func (g *Generate) resolveEmbeds() error {
+	for _, e := range g.embeds {
+		if err := g.resolveEmbed(e); err != nil {
+			return fmt.Errorf("failed to resolve embed patterns for %s: %w", e.dir, err)
+		}
+	}
+	return nil
+}
+
+func (g *Generate) resolveEmbed(e embedPkg) error {
+	root := filepath.Join(g.srcRoot, e.dir)
+	var local []string
+	subpkgFiles := map[string][]string{}
+	for _, pattern := range e.patterns {
+		files, err := embed.Glob(root, pattern)
+		if err != nil {
+			// The go tool would fail to build this package too, but it might not be needed so don't fail everything.
+			log.Printf("warning: %s: %s", root, err)
+			continue
+		}
+		for _, f := range files {
+			if g.isBuildFile(f) {
+				// We've generated this, so it's not something the go tool would have seen.
+				continue
+			} else if subpkg := g.owningPackage(root, f); subpkg != "" {
+				subpkgFiles[subpkg] = append(subpkgFiles[subpkg], trimPath(f, subpkg))
+			} else {
+				local = append(local, f)
+			}
+		}
+	}
+	slices.Sort(local)
+	resources := slices.Compact(local)
+
+	subpkgs := make([]string, 0, len(subpkgFiles))
+	for subpkg := range subpkgFiles {
+		subpkgs = append(subpkgs, subpkg)
+	}
+	slices.Sort(subpkgs)
+	filegroupName := "_" + e.name + "#embed"
+	for _, subpkg := range subpkgs {
+		files := subpkgFiles[subpkg]
+		slices.Sort(files)
+		buildFile, err := parseOrCreateBuildFile(filepath.Join(root, subpkg), g.buildFileNames)
+		if err != nil {
+			return err
+		}
+		filegroup := NewRule("filegroup", filegroupName)
+		filegroup.SetAttr("srcs", NewStringList(slices.Compact(files)))
+		filegroup.SetAttr("visibility", NewStringList([]string{buildTarget("all", e.dir, "")}))
+		buildFile.Stmt = append(buildFile.Stmt, filegroup.Call)
+		if err := saveBuildFile(buildFile); err != nil {
+			return err
+		}
+		resources = append(resources, buildTarget(filegroupName, path.Join(e.dir, subpkg), ""))
+	}
+
+	buildFile, err := parseOrCreateBuildFile(root, g.buildFileNames)
+	if err != nil {
+		return err
+	}
+	rule := bazeledit.FindRuleByName(buildFile, e.name)
+	if rule == nil {
+		return fmt.Errorf("couldn't find rule %s", e.name)
+	}
+	rule.SetAttr("resources", NewStringList(resources))
+	return saveBuildFile(buildFile)
+}
+
+// owningPackage returns the directory of the deepest package below root that contains the given file, relative to
+// root, or the empty string if the file is in root's own package.
+func (g *Generate) owningPackage(root, file string) string {
+	for dir := filepath.Dir(file); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if g.hasBuildFile(filepath.Join(root, dir)) {
+			return dir
+		}
+	}
+	return ""
+}
+
+func (g *Generate) hasBuildFile(dir string) bool {
+	for _, name := range g.buildFileNames {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestPackage describes a package and the rule generated for it for the manifest.
+func (g *Generate) manifestPackage(pkg *build.Package, dir string, rule *Rule) *manifest.Package {
+	importPath := g.moduleName
+	if dir != "" && dir != "." {
+		importPath = path.Join(g.moduleName, dir)
+	}
+	var unresolved []string
+	for _, i := range pkg.Imports {
+		if g.depTarget(i) == "" && !isStdlib(i) {
+			unresolved = append(unresolved, i)
+		}
+	}
+	return &manifest.Package{
+		ImportPath:        importPath,
+		Dir:               dir,
+		Target:            buildTarget(rule.name, dir, g.subrepo),
+		Kind:              rule.kind,
+		Imports:           pkg.Imports,
+		Deps:              rule.deps,
+		UnresolvedImports: unresolved,
+		EmbedPatterns:     pkg.EmbedPatterns,
+		Cgo:               len(pkg.CgoFiles) > 0,
+	}
+}
+
+// isStdlib returns true if the import path looks like it's from the standard library. Like the go tool, we assume
+// that it is if the first element of the path doesn't contain a dot.
+func isStdlib(importPath string) bool {
+	first, _, _ := strings.Cut(importPath, "/")
+	return !strings.Contains(first, ".")
+}
+
+// ignoredMainRules returns go_binary rules for any main package files excluded by the ignore build tag. These are
+// typically code generators run via go:generate. Nothing is returned unless we've been asked to generate them.
+func (g *Generate) ignoredMainRules(pkg *build.Package, dir string) ([]*Rule, error) {
+	if !g.ignoredMains {
+		return nil, nil
+	}
+	ctxt := g.buildContext
+	ctxt.BuildTags = append(slices.Clone(ctxt.BuildTags), "ignore")
+
+	libName := nameForLibInPkg(g.moduleName, trimPath(dir, g.srcRoot))
+	var rules []*Rule
+	for _, file := range pkg.IgnoredGoFiles {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		if match, err := g.buildContext.MatchFile(pkg.Dir, file); err != nil || match {
+			// Either broken, or excluded for some reason other than the ignore tag
+			continue
+		}
+		if match, err := ctxt.MatchFile(pkg.Dir, file); err != nil || !match {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(pkg.Dir, file), nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		if f.Name.Name != "main" {
+			continue
+		}
+		imports := make([]string, 0, len(f.Imports))
+		for _, imp := range f.Imports {
+			imports = append(imports, strings.Trim(imp.Path.Value, `"`))
+		}
+		name := strings.TrimSuffix(file, ".go")
+		if name == libName {
+			name += "_main"
+		}
+		rules = append(rules, &Rule{
+			name:         name,
+			kind:         "go_binary",
+			srcs:         []string{file},
+			deps:         g.depTargets(imports),
+			isCMD:        true,
+			noFilterSrcs: true,
+		})
+	}
+	return rules, nil
 }
 
 func (g *Generate) matchesInstall(dir string) bool {
@@ -361,14 +798,14 @@ func saveBuildFile(buildFile *bazelbuild.File) error {
 	return err
 }
 
-func (g *Generate) createBuildFile(pkg string, rule *Rule, aFiles []string) error {
+func (g *Generate) createBuildFile(pkg string, rule *Rule, binaries []*Rule, aFiles []string) error {
 	buildFile, err := parseOrCreateBuildFile(g.pkgDir(pkg), g.buildFileNames)
 	if err != nil {
 		return err
 	}
 
 	var subincludes []bazelbuild.Expr
-	if strings.HasPrefix(rule.kind, "cgo") {
+	if rule != nil && strings.HasPrefix(rule.kind, "cgo") {
 		subincludes = []bazelbuild.Expr{NewStringExpr("///go//build_defs:cgo")}
 	} else {
 		subincludes = []bazelbuild.Expr{NewStringExpr("///go//build_defs:go")}
@@ -381,7 +818,12 @@ func (g *Generate) createBuildFile(pkg string, rule *Rule, aFiles []string) erro
 		},
 	}
 
-	buildFile.Stmt = append(buildFile.Stmt, g.rule(rule).Call)
+	if rule != nil {
+		buildFile.Stmt = append(buildFile.Stmt, g.rule(rule).Call)
+	}
+	for _, binary := range binaries {
+		buildFile.Stmt = append(buildFile.Stmt, g.rule(binary).Call)
+	}
 
 	if len(aFiles) != 0 {
 		filegroup := NewRule("filegroup", "a_files")
@@ -473,7 +915,6 @@ func (g *Generate) ruleForPackage(pkg *build.Package, dir string) *Rule {
 		asmFiles:       pkg.SFiles,
 		hdrs:           pkg.HFiles,
 		deps:           deps,
-		embedPatterns:  pkg.EmbedPatterns,
 		isCMD:          pkg.IsCommand(),
 		isLargePackage: slices.Contains(g.largePackages, dir),
 	}
@@ -492,21 +933,15 @@ func (g *Generate) depTarget(importPath string) string {
 		return target
 	}
 
-	if replacement, ok := g.replace[importPath]; ok && replacement != importPath {
-		target := g.depTarget(replacement)
+	// The module we're generating may itself be replaced, but its source is what we're generating rules for, so
+	// imports of its packages resolve within it rather than following the replacement.
+	if replacement, ok := g.replace[importPath]; ok && replacement.New.Path != importPath && replacement.Old.Path != g.moduleName {
+		target := g.depTarget(replacement.New.Path)
 		g.knownImportTargets[importPath] = target
 		return target
 	}
 
-	module := ""
-	for _, mod := range append(g.moduleDeps, g.moduleName) {
-		if strings.HasPrefix(importPath, mod) {
-			if len(module) < len(mod) {
-				module = mod
-			}
-		}
-	}
-
+	module := g.moduleForImport(importPath)
 	if module == "" {
 		// If we can't find this import, we can return nothing and the build rule will fail at build time reporting a
 		// sensible error. It may also be an import from the go SDK which is fine.
@@ -522,6 +957,37 @@ func (g *Generate) depTarget(importPath string) string {
 	return target
 }
 
+// moduleForImport returns the module that provides the import path, i.e. the longest known module path that's a prefix
+// of it, or an empty string if there isn't one. A major version suffix following that module path, e.g. the v2 in
+// github.com/foo/bar/v2/baz, means the import is from that major version's module rather than a package of the one we
+// matched, so that module is returned instead even if it's not one we know about.
+func (g *Generate) moduleForImport(importPath string) string {
+	longest := ""
+	for _, mod := range append(g.moduleDeps, g.moduleName) {
+		if hasPathPrefix(importPath, mod) && len(longest) < len(mod) {
+			longest = mod
+		}
+	}
+	if longest == "" || longest == importPath {
+		return longest
+	}
+	if _, major, _ := module.SplitPathVersion(longest); major != "" {
+		return longest
+	}
+
+	next, _, _ := strings.Cut(strings.TrimPrefix(importPath, longest+"/"), "/")
+	if prefix, major, ok := module.SplitPathVersion(longest + "/" + next); ok && major != "" && prefix == longest {
+		return longest + "/" + next
+	}
+	return longest
+}
+
+// hasPathPrefix is like strings.HasPrefix but only matches whole path components, so github.com/foo/bar is a prefix of
+// github.com/foo/bar/baz but not of github.com/foo/barbaz.
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
 // nameForLibInPkg returns the lib target name for a target in pkg. The pkg should be the relative pkg part excluding
 // the module, e.g. pkg would be asset, and module would be github.com/stretchr/testify for
 // github.com/stretchr/testify/assert,
@@ -556,25 +1022,34 @@ func trimPath(target, base string) string {
 	return strings.Join(targetParts[len(baseParts):], "/")
 }
 
-// libTargetForBuildFile finds the go_library or cgo_library target in the package
-func (g *Generate) libTargetForBuildFile(path string) (string, error) {
+// installTargetForBuildFile finds the go_library or cgo_library target in the package. If there isn't one, it looks for
+// the go_binary or cgo_binary target of a main package instead, and reports that the target is a binary.
+func (g *Generate) installTargetForBuildFile(path string) (string, bool, error) {
 	bs, err := os.ReadFile(filepath.Join(g.srcRoot, path))
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 	file, err := bazelbuild.ParseBuild(path, bs)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
+	pkgDir := filepath.Dir(path)
 	libs := append(file.Rules("go_library"), file.Rules("cgo_library")...)
 	if len(libs) >= 1 {
 		if len(libs) != 1 {
 			log.Fatalf("more than one go library in installed package %v", path)
 		}
-		return buildTarget(libs[0].Name(), filepath.Dir(path), ""), nil
+		return buildTarget(libs[0].Name(), pkgDir, ""), false, nil
+	}
+	// Binaries for ignored generator mains can live alongside the package's own, so pick the one named for the package.
+	name := nameForLibInPkg(g.moduleName, pkgDir)
+	for _, bin := range append(file.Rules("go_binary"), file.Rules("cgo_binary")...) {
+		if bin.Name() == name {
+			return buildTarget(name, pkgDir, ""), true, nil
+		}
 	}
-	return "", nil
+	return "", false, nil
 }
 
 func (g *Generate) subrepoName(module string) string {
@@ -584,22 +1059,18 @@ func (g *Generate) subrepoName(module string) string {
 	return filepath.Join(g.thirdPartyFolder, strings.ReplaceAll(module, "/", "_"))
 }
 
-func (g *Generate) libTargetForBuildPackage(i string) (string, error) {
+func (g *Generate) installTargetForBuildPackage(i string) (string, bool, error) {
 	entries, err := os.ReadDir(filepath.Join(g.srcRoot, i))
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
 	for _, e := range entries {
 		if g.isBuildFile(e.Name()) {
-			t, err := g.libTargetForBuildFile(filepath.Join(i, e.Name()))
-			if err != nil {
-				return "", err
-			}
-			return t, nil
+			return g.installTargetForBuildFile(filepath.Join(i, e.Name()))
 		}
 	}
-	return "", nil
+	return "", false, nil
 }
 
 func buildTarget(name, pkgDir, subrepo string) string {
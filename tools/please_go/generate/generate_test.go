@@ -1,8 +1,17 @@
 package generate
 
 import (
-	"github.com/stretchr/testify/assert"
+	"go/build"
+	"os"
+	"path/filepath"
 	"testing"
+
+	bazelbuild "github.com/bazelbuild/buildtools/build"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
+
+	"github.com/please-build/go-rules/tools/please_go/generate/gomoddeps"
 )
 
 func TestTrimPath(t *testing.T) {
@@ -100,6 +109,7 @@ func TestDepTarget(t *testing.T) {
 	tests := []struct {
 		name         string
 		deps         []string
+		replace      []gomoddeps.Replace
 		importTarget string
 		expected     string
 	}{
@@ -142,6 +152,83 @@ func TestDepTarget(t *testing.T) {
 			expected:     "///third_party/go/github.com_some_module//all:lib",
 			deps:         []string{"github.com/some/module"},
 		},
+		{
+			name:         "only matches whole path components",
+			importTarget: "github.com/some/modulefoo/bar",
+			expected:     "///third_party/go/github.com_some_modulefoo//bar",
+			deps:         []string{"github.com/some/module", "github.com/some/modulefoo"},
+		},
+		{
+			name:         "doesn't match a module that's a string prefix",
+			importTarget: "github.com/some/modulefoo/bar",
+			expected:     "",
+			deps:         []string{"github.com/some/module"},
+		},
+		{
+			name:         "resolves major version to its own module",
+			importTarget: "github.com/some/module/v2/foo",
+			expected:     "///third_party/go/github.com_some_module_v2//foo",
+			deps:         []string{"github.com/some/module", "github.com/some/module/v2"},
+		},
+		{
+			name:         "resolves earlier major version alongside a later one",
+			importTarget: "github.com/some/module/foo",
+			expected:     "///third_party/go/github.com_some_module//foo",
+			deps:         []string{"github.com/some/module/v2", "github.com/some/module"},
+		},
+		{
+			name:         "resolves unknown major version to its own module",
+			importTarget: "github.com/some/module/v3/foo",
+			expected:     "///third_party/go/github.com_some_module_v3//foo",
+			deps:         []string{"github.com/some/module", "github.com/some/module/v2"},
+		},
+		{
+			name:         "package named like a major version in a major version module",
+			importTarget: "github.com/some/module/v2/v3",
+			expected:     "///third_party/go/github.com_some_module_v2//v3",
+			deps:         []string{"github.com/some/module/v2"},
+		},
+		{
+			name:         "resolves gopkg.in major versions",
+			importTarget: "gopkg.in/yaml.v3",
+			expected:     "///third_party/go/gopkg.in_yaml.v3//:yaml.v3",
+			deps:         []string{"gopkg.in/yaml.v2", "gopkg.in/yaml.v3"},
+		},
+		{
+			name:         "resolves gopkg.in packages",
+			importTarget: "gopkg.in/yaml.v2/foo",
+			expected:     "///third_party/go/gopkg.in_yaml.v2//foo",
+			deps:         []string{"gopkg.in/yaml.v2", "gopkg.in/yaml.v3"},
+		},
+		{
+			name:         "follows replacement to another module",
+			importTarget: "github.com/some/module",
+			expected:     "///third_party/go/github.com_some_fork//:fork",
+			deps:         []string{"github.com/some/module", "github.com/some/fork"},
+			replace: []gomoddeps.Replace{{
+				Old: module.Version{Path: "github.com/some/module", Version: "v1.2.3"},
+				New: module.Version{Path: "github.com/some/fork", Version: "v1.4.0"},
+			}},
+		},
+		{
+			name:         "replacement with another version of the same module",
+			importTarget: "github.com/some/module",
+			expected:     "///third_party/go/github.com_some_module//:module",
+			deps:         []string{"github.com/some/module"},
+			replace: []gomoddeps.Replace{{
+				Old: module.Version{Path: "github.com/some/module", Version: "v1.2.3"},
+				New: module.Version{Path: "github.com/some/module", Version: "v1.4.0"},
+			}},
+		},
+		{
+			name:         "doesn't follow the replacement of the module being generated",
+			importTarget: "github.com/this/module",
+			expected:     "//:module",
+			replace: []gomoddeps.Replace{{
+				Old: module.Version{Path: "github.com/this/module", Version: "v1.0.0"},
+				New: module.Version{Path: "github.com/this/fork", Version: "v1.1.0"},
+			}},
+		},
 	}
 
 	for _, test := range tests {
@@ -149,12 +236,200 @@ func TestDepTarget(t *testing.T) {
 			g := &Generate{
 				moduleName:         "github.com/this/module",
 				thirdPartyFolder:   "third_party/go",
-				replace:            map[string]string{},
+				replace:            map[string]gomoddeps.Replace{},
 				knownImportTargets: map[string]string{},
 				moduleDeps:         test.deps,
 			}
+			for _, replace := range test.replace {
+				g.replace[replace.Old.Path] = replace
+			}
 
 			assert.Equal(t, test.expected, g.depTarget(test.importTarget))
 		})
 	}
 }
+
+func TestImportDirWithClashingPackages(t *testing.T) {
+	g := &Generate{
+		moduleName:         "example.com/generate",
+		srcRoot:            "tools/please_go/generate/test_data",
+		buildContext:       build.Default,
+		knownImportTargets: map[string]string{},
+	}
+	pkg, err := g.importDir("example.com/generate/clash")
+	require.NoError(t, err)
+	assert.Equal(t, "clash", pkg.Name)
+	assert.Equal(t, []string{"clash.go"}, pkg.GoFiles)
+}
+
+func TestIgnoredMainRules(t *testing.T) {
+	g := &Generate{
+		moduleName:         "example.com/generate",
+		srcRoot:            "tools/please_go/generate/test_data",
+		buildContext:       build.Default,
+		knownImportTargets: map[string]string{},
+		ignoredMains:       true,
+	}
+	pkg, err := g.importDir("example.com/generate/clash")
+	require.NoError(t, err)
+	rules, err := g.ignoredMainRules(pkg, "clash")
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "gen", rules[0].name)
+	assert.Equal(t, "go_binary", rules[0].kind)
+	assert.Equal(t, []string{"gen.go"}, rules[0].srcs)
+	assert.Equal(t, []string{"//clash"}, rules[0].deps)
+	assert.True(t, rules[0].noFilterSrcs)
+
+	// A directory containing nothing but the generator should still give us a binary.
+	pkg, err = g.importDir("example.com/generate/gen")
+	assert.IsType(t, &build.NoGoError{}, err)
+	rules, err = g.ignoredMainRules(pkg, "gen")
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "gen_main", rules[0].name)
+}
+
+func TestChoosePackage(t *testing.T) {
+	assert.Equal(t, "foo", choosePackage("foo", map[string][]string{"main": {"a.go", "b.go"}, "foo": {"c.go"}}))
+	assert.Equal(t, "bar", choosePackage("foo", map[string][]string{"main": {"a.go", "b.go"}, "bar": {"c.go"}}))
+	assert.Equal(t, "baz", choosePackage("foo", map[string][]string{"bar": {"a.go"}, "baz": {"b.go", "c.go"}}))
+}
+
+func TestInstallTargets(t *testing.T) {
+	root := t.TempDir()
+	writeFile := func(path, contents string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(contents), 0644))
+	}
+	writeFile("lib/BUILD", `go_library(name = "lib", srcs = ["lib.go"])`)
+	writeFile("cmd/tool/BUILD", `go_binary(name = "tool", srcs = ["tool.go"])
+go_binary(name = "gen", srcs = ["gen.go"], filter_srcs = False)`)
+
+	g := &Generate{
+		moduleName:     "example.com/module",
+		srcRoot:        root,
+		buildFileNames: []string{"BUILD"},
+		install:        []string{"lib", "cmd/..."},
+	}
+	libs, binaries, err := g.installTargets()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"//lib"}, libs)
+	assert.Equal(t, []string{"//cmd/tool"}, binaries)
+
+	g.install = []string{"cmd/tool"}
+	libs, binaries, err = g.installTargets()
+	require.NoError(t, err)
+	assert.Empty(t, libs)
+	assert.Equal(t, []string{"//cmd/tool"}, binaries)
+}
+
+func TestBinariesRule(t *testing.T) {
+	g := &Generate{
+		binaries: []binary{
+			{name: "protoc-gen-go", pkg: "cmd/protoc-gen-go"},
+			{name: "tool", pkg: "a/tool"},
+			{name: "tool", pkg: "b/tool"},
+		},
+	}
+	r := g.binariesRule()
+	assert.Equal(t, []string{"//cmd/protoc-gen-go", "//a/tool", "//b/tool"}, r.AttrStrings("srcs"))
+	assert.Equal(t, "mkdir -p $OUT && cp $(location //cmd/protoc-gen-go) $OUT/protoc-gen-go && cp $(location //a/tool) $OUT/a_tool && cp $(location //b/tool) $OUT/b_tool", r.AttrString("cmd"))
+	entryPoints, ok := r.Attr("entry_points").(*bazelbuild.DictExpr)
+	require.True(t, ok)
+	keys := make([]string, 0, len(entryPoints.List))
+	for _, kv := range entryPoints.List {
+		keys = append(keys, kv.Key.(*bazelbuild.StringExpr).Value)
+	}
+	assert.Equal(t, []string{"protoc-gen-go", "a_tool", "b_tool"}, keys)
+}
+
+func TestBinariesRuleWithRootPackage(t *testing.T) {
+	g := &Generate{
+		moduleName: "example.com/tool",
+		binaries: []binary{
+			{name: "tool", pkg: ""},
+			{name: "tool", pkg: "tool"},
+			{name: "other", pkg: "cmd/other"},
+		},
+	}
+	r := g.binariesRule()
+	assert.Equal(t, "mkdir -p $OUT && cp $(location //:tool) $OUT/example.com_tool && cp $(location //tool) $OUT/tool && cp $(location //cmd/other) $OUT/other", r.AttrString("cmd"))
+}
+
+func TestManifestPackage(t *testing.T) {
+	g := &Generate{
+		moduleName:         "github.com/this/module",
+		subrepo:            "third_party/go/github.com_this_module",
+		thirdPartyFolder:   "third_party/go",
+		replace:            map[string]gomoddeps.Replace{},
+		knownImportTargets: map[string]string{},
+		moduleDeps:         []string{"github.com/some/module"},
+	}
+	pkg := &build.Package{
+		Imports:       []string{"fmt", "github.com/some/module/foo", "github.com/missing/module"},
+		EmbedPatterns: []string{"*.txt"},
+		GoFiles:       []string{"bar.go"},
+	}
+	rule := g.ruleForPackage(pkg, "bar")
+	p := g.manifestPackage(pkg, "bar", rule)
+	assert.Equal(t, "github.com/this/module/bar", p.ImportPath)
+	assert.Equal(t, "///third_party/go/github.com_this_module//bar", p.Target)
+	assert.Equal(t, "go_library", p.Kind)
+	assert.Equal(t, []string{"///third_party/go/github.com_some_module//foo"}, p.Deps)
+	assert.Equal(t, []string{"github.com/missing/module"}, p.UnresolvedImports)
+	assert.Equal(t, []string{"*.txt"}, p.EmbedPatterns)
+	assert.False(t, p.Cgo)
+}
+
+func TestResolveEmbed(t *testing.T) {
+	root := t.TempDir()
+	writeFile := func(path, contents string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(contents), 0644))
+	}
+	writeFile("foo/BUILD", `go_library(name = "foo", srcs = ["foo.go"])`)
+	writeFile("foo/x.txt", "x")
+	writeFile("foo/static/a.txt", "a")
+	writeFile("foo/static/.hidden", "hidden")
+	writeFile("foo/sub/BUILD", `go_library(name = "sub", srcs = ["sub.go"])`)
+	writeFile("foo/sub/b.txt", "b")
+	writeFile("foo/sub/deeper/c.txt", "c")
+
+	g := &Generate{
+		srcRoot:        root,
+		buildFileNames: []string{"BUILD"},
+	}
+	require.NoError(t, g.resolveEmbed(embedPkg{dir: "foo", name: "foo", patterns: []string{"x.txt", "static", "sub", "missing/*"}}))
+
+	parse := func(path string) *bazelbuild.File {
+		b, err := os.ReadFile(filepath.Join(root, path))
+		require.NoError(t, err)
+		f, err := bazelbuild.ParseBuild(path, b)
+		require.NoError(t, err)
+		return f
+	}
+	lib := parse("foo/BUILD").Rules("go_library")[0]
+	assert.Equal(t, []string{"static/a.txt", "x.txt", "//foo/sub:_foo#embed"}, lib.AttrStrings("resources"))
+
+	filegroups := parse("foo/sub/BUILD").Rules("filegroup")
+	require.Len(t, filegroups, 1)
+	assert.Equal(t, "_foo#embed", filegroups[0].Name())
+	assert.Equal(t, []string{"b.txt", "deeper/c.txt"}, filegroups[0].AttrStrings("srcs"))
+	assert.Equal(t, []string{"//foo:all"}, filegroups[0].AttrStrings("visibility"))
+}
+
+func TestSetModuleReplacement(t *testing.T) {
+	g := &Generate{
+		moduleName: "github.com/some/module",
+		moduleArg:  "github.com/some/module",
+		replace: map[string]gomoddeps.Replace{
+			"github.com/some/module": {
+				Old: module.Version{Path: "github.com/some/module", Version: "v1.2.3"},
+				New: module.Version{Path: "github.com/some/fork", Version: "v1.4.0"},
+			},
+		},
+	}
+	g.setModuleReplacement()
+	assert.Equal(t, "github.com/some/module@v1.2.3 => github.com/some/fork@v1.4.0", g.moduleArg)
+}
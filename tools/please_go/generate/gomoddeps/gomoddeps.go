@@ -5,35 +5,41 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"os"
+	"slices"
 
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 )
 
 // GetCombinedDepsAndReplacements returns dependencies and replacements after inspecting both
 // the host and the module go.mod files.
 // Module's replacement are only returned if there is no host go.mod file.
-func GetCombinedDepsAndReplacements(hostGoModPath, moduleGoModPath string) ([]string, map[string]string, error) {
+// Requirements on versions excluded by the host go.mod (or the module's, if there's no host go.mod) are ignored.
+func GetCombinedDepsAndReplacements(hostGoModPath, moduleGoModPath string) ([]string, []Replace, error) {
 	var err error
 
 	hostDeps := []string{}
-	hostReplacements := map[string]string{}
+	var hostReplacements []Replace
+	var hostExcludes []module.Version
 	if hostGoModPath != "" {
-		hostDeps, hostReplacements, err = getDepsAndReplacements(hostGoModPath, false)
+		hostDeps, hostReplacements, hostExcludes, err = getDepsAndReplacements(hostGoModPath, false, nil)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to read host repo go.mod %q: %w", hostGoModPath, err)
 		}
 	}
 
 	var moduleDeps []string
-	var moduleReplacements = map[string]string{}
+	var moduleReplacements []Replace
 	useLaxParsingForModule := true
 	if hostGoModPath == "" {
 		// If we're only considering the module then we want to extract the replacement's as well (lax mode
 		// doesn't parse them).
 		useLaxParsingForModule = false
 	}
-	moduleDeps, moduleReplacements, err = getDepsAndReplacements(moduleGoModPath, useLaxParsingForModule)
+	moduleDeps, moduleReplacements, _, err = getDepsAndReplacements(moduleGoModPath, useLaxParsingForModule, hostExcludes)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return hostDeps, hostReplacements, nil
@@ -41,7 +47,7 @@ func GetCombinedDepsAndReplacements(hostGoModPath, moduleGoModPath string) ([]st
 		return nil, nil, fmt.Errorf("failed to read module go.mod %q: %w", moduleGoModPath, err)
 	}
 
-	var replacements map[string]string
+	var replacements []Replace
 	if hostGoModPath == "" {
 		replacements = moduleReplacements
 	} else {
@@ -52,13 +58,65 @@ func GetCombinedDepsAndReplacements(hostGoModPath, moduleGoModPath string) ([]st
 }
 
 // getDepsAndReplacements parses the go.mod file and returns all the dependencies
-// and replacement directives from it.
-func getDepsAndReplacements(goModPath string, useLaxParsing bool) ([]string, map[string]string, error) {
-	data, err := os.ReadFile(goModPath)
+// and the replacements that apply to them, along with its exclusions. Requirements excluded
+// either by this go.mod or the given exclusions are skipped. Note that this differs from the
+// go tool, which moves on to the next version of the module that isn't excluded: we only
+// deal in module paths here, with the version coming from whichever go_repo the host repo
+// has, so if the module is still needed the host go.mod has to require it directly.
+func getDepsAndReplacements(goModPath string, useLaxParsing bool, excludes []module.Version) ([]string, []Replace, []module.Version, error) {
+	goMod, err := ReadGoMod(goModPath, useLaxParsing)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	excludes = append(excludes, goMod.Excludes...)
+
+	moduleDeps := make([]string, 0, len(goMod.Requires))
+	// TODO we could probably validate these are known modules
+	for _, req := range goMod.Requires {
+		if slices.Contains(excludes, req.Version) {
+			log.Printf("warning: ignoring requirement on %s in %s, which is excluded", req.Version, goModPath)
+			continue
+		}
+		moduleDeps = append(moduleDeps, req.Path)
 	}
 
+	return moduleDeps, goMod.ResolvedReplacements(), goMod.Excludes, nil
+}
+
+// GoMod is the parsed contents of a go.mod file
+type GoMod struct {
+	Module   string
+	Requires []Require
+	Replaces []Replace
+	Excludes []module.Version
+	Retracts []Retract
+}
+
+// Require is a requirement on another module
+type Require struct {
+	module.Version
+	Indirect bool
+}
+
+// Replace is a replace directive. Old.Version is empty if all versions are replaced, and New.Version is empty if the
+// replacement is a local directory.
+type Replace struct {
+	Old, New module.Version
+}
+
+// Retract is a range of versions of the module that have been retracted
+type Retract struct {
+	Low, High string
+	Rationale string
+}
+
+// ReadGoMod reads and parses a go.mod file. Lax parsing should be used for the go.mod files of dependencies; it
+// ignores their replace and exclude directives, which only apply to the main module.
+func ReadGoMod(goModPath string, useLaxParsing bool) (*GoMod, error) {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, err
+	}
 	var modFile *modfile.File
 	if useLaxParsing {
 		modFile, err = modfile.ParseLax(goModPath, data, nil)
@@ -66,19 +124,94 @@ func getDepsAndReplacements(goModPath string, useLaxParsing bool) ([]string, map
 		modFile, err = modfile.Parse(goModPath, data, nil)
 	}
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	moduleDeps := make([]string, 0, len(modFile.Require))
-	// TODO we could probably validate these are known modules
+	goMod := &GoMod{}
+	if modFile.Module != nil {
+		goMod.Module = modFile.Module.Mod.Path
+	}
 	for _, req := range modFile.Require {
-		moduleDeps = append(moduleDeps, req.Mod.Path)
+		goMod.Requires = append(goMod.Requires, Require{Version: req.Mod, Indirect: req.Indirect})
 	}
-
-	replacements := make(map[string]string, len(modFile.Replace))
 	for _, replace := range modFile.Replace {
-		replacements[replace.Old.Path] = replace.New.Path
+		goMod.Replaces = append(goMod.Replaces, Replace{Old: replace.Old, New: replace.New})
+	}
+	for _, exclude := range modFile.Exclude {
+		goMod.Excludes = append(goMod.Excludes, exclude.Mod)
 	}
+	for _, retract := range modFile.Retract {
+		goMod.Retracts = append(goMod.Retracts, Retract{Low: retract.Low, High: retract.High, Rationale: retract.Rationale})
+	}
+	return goMod, nil
+}
+
+// Replacement returns the replacement for the given module version, if there is one. A replacement of that specific
+// version takes precedence over one for all versions.
+func (goMod *GoMod) Replacement(mod module.Version) (module.Version, bool) {
+	var replacement *module.Version
+	for i, replace := range goMod.Replaces {
+		if replace.Old.Path != mod.Path {
+			continue
+		} else if replace.Old.Version == mod.Version {
+			return replace.New, true
+		} else if replace.Old.Version == "" {
+			replacement = &goMod.Replaces[i].New
+		}
+	}
+	if replacement == nil {
+		return module.Version{}, false
+	}
+	return *replacement, true
+}
+
+// ResolvedReplacements returns the replacements that apply to this go.mod's requirements, with Old.Version set to the
+// version that's required. Like the go command, a replacement of a specific version only applies if that version is
+// the one required. Replacements of all versions of modules that aren't required are returned with no Old.Version.
+func (goMod *GoMod) ResolvedReplacements() []Replace {
+	var replacements []Replace
+	required := map[string]bool{}
+	for _, req := range goMod.Requires {
+		required[req.Path] = true
+		if replacement, ok := goMod.Replacement(req.Version); ok {
+			replacements = append(replacements, Replace{Old: req.Version, New: replacement})
+		}
+	}
+	for _, replace := range goMod.Replaces {
+		if !required[replace.Old.Path] && replace.Old.Version == "" {
+			replacements = append(replacements, replace)
+		}
+	}
+	return replacements
+}
+
+// Excluded returns true if the given module version is excluded.
+func (goMod *GoMod) Excluded(mod module.Version) bool {
+	for _, exclude := range goMod.Excludes {
+		if exclude == mod {
+			return true
+		}
+	}
+	return false
+}
 
-	return moduleDeps, replacements, nil
+// Retraction returns the retract directive covering the given version of this module, if there is one.
+func (goMod *GoMod) Retraction(version string) (Retract, bool) {
+	for _, retract := range goMod.Retracts {
+		if semver.Compare(retract.Low, version) <= 0 && semver.Compare(version, retract.High) <= 0 {
+			return retract, true
+		}
+	}
+	return Retract{}, false
+}
+
+// CheckRetracted returns an error with the rationale if the given version of this module is retracted.
+func (goMod *GoMod) CheckRetracted(version string) error {
+	retract, retracted := goMod.Retraction(version)
+	if !retracted {
+		return nil
+	} else if retract.Rationale == "" {
+		return fmt.Errorf("%s@%s has been retracted", goMod.Module, version)
+	}
+	return fmt.Errorf("%s@%s has been retracted: %s", goMod.Module, version, retract.Rationale)
 }
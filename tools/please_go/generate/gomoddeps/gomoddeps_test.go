@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
 )
 
 var hostGoModPath = "tools/please_go/generate/gomoddeps/test_data/host_go_mod"
@@ -53,8 +55,10 @@ func TestHostOnlyDeps(t *testing.T) {
 		_, replacements, err := GetCombinedDepsAndReplacements(hostGoModPath, "/does/not/matter")
 		assert.NoError(t, err)
 
-		assert.Len(t, replacements, 1)
-		assert.Equal(t, map[string]string{"example.com/bob": "example.com/new-bob"}, replacements)
+		assert.Equal(t, []Replace{{
+			Old: module.Version{Path: "example.com/bob", Version: "v1.2.2"},
+			New: module.Version{Path: "example.com/new-bob", Version: "v42.0.0"},
+		}}, replacements)
 	})
 }
 
@@ -71,8 +75,10 @@ func TestModuleOnlyDeps(t *testing.T) {
 		_, replacements, err := GetCombinedDepsAndReplacements("", moduleGoModPath)
 		assert.NoError(t, err)
 
-		assert.Len(t, replacements, 1)
-		assert.Equal(t, map[string]string{"example.com/bab": "example.com/new-bab"}, replacements)
+		assert.Equal(t, []Replace{{
+			Old: module.Version{Path: "example.com/bab", Version: "v1.2.2"},
+			New: module.Version{Path: "example.com/new-bab", Version: "v42.0.0"},
+		}}, replacements)
 	})
 }
 
@@ -89,7 +95,83 @@ func TestBothDeps(t *testing.T) {
 		_, replacements, err := GetCombinedDepsAndReplacements(hostGoModPath, moduleGoModPath)
 		assert.NoError(t, err)
 
-		assert.Len(t, replacements, 1)
-		assert.Equal(t, map[string]string{"example.com/bob": "example.com/new-bob"}, replacements)
+		assert.Equal(t, []Replace{{
+			Old: module.Version{Path: "example.com/bob", Version: "v1.2.2"},
+			New: module.Version{Path: "example.com/new-bob", Version: "v42.0.0"},
+		}}, replacements)
 	})
 }
+
+func TestReadGoMod(t *testing.T) {
+	goMod, err := ReadGoMod("tools/please_go/generate/gomoddeps/test_data/full_go_mod", false)
+	require.NoError(t, err)
+
+	assert.Equal(t, "example.com/full", goMod.Module)
+	assert.Equal(t, []Require{
+		{Version: module.Version{Path: "example.com/foo", Version: "v1.0.0"}},
+		{Version: module.Version{Path: "example.com/bar", Version: "v1.2.0"}, Indirect: true},
+		{Version: module.Version{Path: "example.com/bob", Version: "v1.2.2"}},
+	}, goMod.Requires)
+	assert.Equal(t, []module.Version{{Path: "example.com/foo", Version: "v1.1.0"}}, goMod.Excludes)
+	assert.Equal(t, []Retract{{Low: "v1.0.0", High: "v1.0.5", Rationale: "Published too early"}}, goMod.Retracts)
+
+	assert.True(t, goMod.Excluded(module.Version{Path: "example.com/foo", Version: "v1.1.0"}))
+	assert.False(t, goMod.Excluded(module.Version{Path: "example.com/foo", Version: "v1.0.0"}))
+}
+
+func TestReplacement(t *testing.T) {
+	goMod, err := ReadGoMod("tools/please_go/generate/gomoddeps/test_data/full_go_mod", false)
+	require.NoError(t, err)
+
+	t.Run("version specific replacement takes precedence", func(t *testing.T) {
+		replacement, ok := goMod.Replacement(module.Version{Path: "example.com/bob", Version: "v1.2.2"})
+		assert.True(t, ok)
+		assert.Equal(t, module.Version{Path: "../bob"}, replacement)
+	})
+
+	t.Run("falls back to replacing all versions", func(t *testing.T) {
+		replacement, ok := goMod.Replacement(module.Version{Path: "example.com/bob", Version: "v1.3.0"})
+		assert.True(t, ok)
+		assert.Equal(t, module.Version{Path: "example.com/new-bob", Version: "v42.0.0"}, replacement)
+	})
+
+	t.Run("not replaced", func(t *testing.T) {
+		_, ok := goMod.Replacement(module.Version{Path: "example.com/foo", Version: "v1.0.0"})
+		assert.False(t, ok)
+	})
+}
+
+func TestCheckRetracted(t *testing.T) {
+	goMod, err := ReadGoMod("tools/please_go/generate/gomoddeps/test_data/full_go_mod", false)
+	require.NoError(t, err)
+
+	assert.EqualError(t, goMod.CheckRetracted("v1.0.3"), "example.com/full@v1.0.3 has been retracted: Published too early")
+	assert.NoError(t, goMod.CheckRetracted("v1.0.6"))
+}
+
+func TestExcludedDeps(t *testing.T) {
+	deps, _, err := GetCombinedDepsAndReplacements("tools/please_go/generate/gomoddeps/test_data/full_go_mod", "tools/please_go/generate/gomoddeps/test_data/excluding_go_mod")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com/foo", "example.com/bar", "example.com/bob", "example.com/dob"}, deps)
+}
+
+func TestResolvedReplacements(t *testing.T) {
+	goMod := &GoMod{
+		Requires: []Require{
+			{Version: module.Version{Path: "example.com/foo", Version: "v1.2.3"}},
+			{Version: module.Version{Path: "example.com/bar", Version: "v1.0.0"}},
+			{Version: module.Version{Path: "example.com/self", Version: "v1.0.0"}},
+		},
+		Replaces: []Replace{
+			{Old: module.Version{Path: "example.com/foo", Version: "v1.2.3"}, New: module.Version{Path: "example.com/fork", Version: "v1.4.0"}},
+			{Old: module.Version{Path: "example.com/bar", Version: "v0.9.0"}, New: module.Version{Path: "example.com/old-bar", Version: "v0.9.1"}},
+			{Old: module.Version{Path: "example.com/self"}, New: module.Version{Path: "example.com/self", Version: "v1.1.0"}},
+			{Old: module.Version{Path: "example.com/unused"}, New: module.Version{Path: "../unused"}},
+		},
+	}
+	assert.Equal(t, []Replace{
+		{Old: module.Version{Path: "example.com/foo", Version: "v1.2.3"}, New: module.Version{Path: "example.com/fork", Version: "v1.4.0"}},
+		{Old: module.Version{Path: "example.com/self", Version: "v1.0.0"}, New: module.Version{Path: "example.com/self", Version: "v1.1.0"}},
+		{Old: module.Version{Path: "example.com/unused"}, New: module.Version{Path: "../unused"}},
+	}, goMod.ResolvedReplacements())
+}
@@ -0,0 +1,61 @@
+// Package manifest describes the machine-readable record of the targets `please_go generate` writes for a module.
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// FileName is the name of the manifest file written at the root of a generated subrepo.
+const FileName = "go_manifest.json"
+
+// Manifest describes the packages generated for a module.
+type Manifest struct {
+	Module   string     `json:"module"`
+	Version  string     `json:"version,omitempty"`
+	Subrepo  string     `json:"subrepo,omitempty"`
+	Licences []string   `json:"licences,omitempty"`
+	Packages []*Package `json:"packages"`
+}
+
+// Package describes a single package in a module and the target generated for it.
+type Package struct {
+	// ImportPath is the Go import path of the package
+	ImportPath string `json:"import_path"`
+	// Dir is the directory of the package, relative to the module root
+	Dir string `json:"dir"`
+	// Target is the build label of the generated target
+	Target string `json:"target"`
+	// Kind is the kind of rule generated, e.g. go_library or cgo_binary
+	Kind string `json:"kind"`
+	// Imports are all the packages imported by this one
+	Imports []string `json:"imports,omitempty"`
+	// Deps are the build labels that imports resolved to
+	Deps []string `json:"deps,omitempty"`
+	// UnresolvedImports are non-standard library imports that couldn't be resolved to a target
+	UnresolvedImports []string `json:"unresolved_imports,omitempty"`
+	// EmbedPatterns are the patterns from any //go:embed directives
+	EmbedPatterns []string `json:"embed_patterns,omitempty"`
+	// Cgo is true if the package uses cgo
+	Cgo bool `json:"cgo,omitempty"`
+}
+
+// Write writes the manifest into the given directory.
+func (m *Manifest) Write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, FileName), append(b, '\n'), 0644)
+}
+
+// Read reads a manifest from the given directory.
+func Read(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	return m, json.Unmarshal(b, m)
+}
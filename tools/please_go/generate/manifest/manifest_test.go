@@ -0,0 +1,32 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manifest{
+		Module:  "github.com/some/module",
+		Version: "v1.2.3",
+		Packages: []*Package{
+			{
+				ImportPath:        "github.com/some/module/foo",
+				Dir:               "foo",
+				Target:            "///third_party/go/github.com_some_module//foo",
+				Kind:              "go_library",
+				Imports:           []string{"fmt", "github.com/other/module", "github.com/missing/module"},
+				Deps:              []string{"///third_party/go/github.com_other_module//:module"},
+				UnresolvedImports: []string{"github.com/missing/module"},
+				EmbedPatterns:     []string{"*.txt"},
+			},
+		},
+	}
+	require.NoError(t, m.Write(dir))
+	read, err := Read(dir)
+	require.NoError(t, err)
+	assert.Equal(t, m, read)
+}
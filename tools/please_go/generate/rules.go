@@ -16,9 +16,9 @@ type Rule struct {
 	asmFiles       []string
 	hdrs           []string
 	deps           []string
-	embedPatterns  []string
 	isCMD          bool
 	isLargePackage bool
+	noFilterSrcs   bool
 }
 
 func populateRule(r *build.Rule, targetState *Rule) {
@@ -49,18 +49,13 @@ func populateRule(r *build.Rule, targetState *Rule) {
 	if len(targetState.asmFiles) > 0 {
 		r.SetAttr("asm_srcs", NewStringList(targetState.asmFiles))
 	}
-	if len(targetState.embedPatterns) > 0 {
-		r.SetAttr("resources", &build.CallExpr{
-			X: &build.Ident{Name: "glob"},
-			List: []build.Expr{
-				NewStringList(targetState.embedPatterns),
-			},
-		})
-	}
 	if !targetState.isCMD {
 		r.SetAttr("_module", NewStringExpr(targetState.module))
 		r.SetAttr("_subrepo", NewStringExpr(targetState.subrepo))
 	}
+	if targetState.noFilterSrcs {
+		r.SetAttr("filter_srcs", NewBoolExpr(false))
+	}
 	if targetState.isLargePackage {
 		r.SetAttr("_is_large_package", NewBoolExpr(true))
 	}
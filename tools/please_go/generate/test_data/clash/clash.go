@@ -0,0 +1,4 @@
+package clash
+
+// Answer is the answer.
+const Answer = 42
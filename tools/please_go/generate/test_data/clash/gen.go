@@ -0,0 +1,13 @@
+//go:build ignore
+
+package main
+
+import (
+	"os"
+
+	"example.com/generate/clash"
+)
+
+func main() {
+	os.Exit(clash.Answer)
+}
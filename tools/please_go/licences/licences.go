@@ -0,0 +1,144 @@
+// Package licences detects the licences a Go module is released under from the licence files at its root.
+package licences
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// filePrefixes are the prefixes of files that we consider to contain licence text. They're matched case-insensitively
+// so e.g. LICENSE.md, License-MIT and COPYING.txt are all picked up.
+var filePrefixes = []string{"licence", "license", "copying", "notice", "unlicense"}
+
+// classifier identifies a licence by phrases that appear in its (normalised) text.
+type classifier struct {
+	ID      string
+	Phrases []string
+}
+
+// classifiers are matched in order. Headers include the dates of the GNU licences as their texts refer to each other.
+var classifiers = []classifier{
+	{ID: "AGPL-3.0", Phrases: []string{"gnu affero general public license version 3 19 november 2007"}},
+	{ID: "LGPL-3.0", Phrases: []string{"gnu lesser general public license version 3 29 june 2007"}},
+	{ID: "LGPL-2.1", Phrases: []string{"gnu lesser general public license version 2 1 february 1999"}},
+	{ID: "GPL-3.0", Phrases: []string{"gnu general public license version 3 29 june 2007"}},
+	{ID: "GPL-2.0", Phrases: []string{"gnu general public license version 2 june 1991"}},
+	{ID: "MPL-2.0", Phrases: []string{"mozilla public license version 2 0"}},
+	{ID: "EPL-2.0", Phrases: []string{"eclipse public license v 2 0"}},
+	{ID: "Apache-2.0", Phrases: []string{"apache license version 2 0"}},
+	{ID: "BSL-1.0", Phrases: []string{"boost software license version 1 0"}},
+	{ID: "CC0-1.0", Phrases: []string{"cc0 1 0 universal"}},
+	{ID: "Unlicense", Phrases: []string{"this is free and unencumbered software released into the public domain"}},
+	{ID: "ISC", Phrases: []string{"permission to use copy modify and or distribute this software for any purpose with or without fee is hereby granted"}},
+	{ID: "MIT", Phrases: []string{"permission is hereby granted free of charge to any person obtaining a copy"}},
+	{ID: "Zlib", Phrases: []string{"altered source versions must be plainly marked as such and must not be misrepresented as being the original software"}},
+}
+
+// The BSD licences share their text; the 3-clause one adds a non-endorsement clause.
+const bsdPhrase = "redistribution and use in source and binary forms with or without modification are permitted"
+
+var bsd3ClausePhrases = []string{"neither the name", "may not be used to endorse or promote products"}
+
+// Detect returns the SPDX identifiers of the licences found in the licence files in the given directory.
+// The result is sorted and contains no duplicates. No error is returned if there are no licence files.
+func Detect(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isLicenceFile(entry.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Classify(string(b))...)
+	}
+	slices.Sort(ret)
+	return slices.Compact(ret), nil
+}
+
+// Classify returns the SPDX identifiers of the licences whose text appears in the given text.
+func Classify(text string) []string {
+	text = normalise(text)
+	var ret []string
+	for _, c := range classifiers {
+		if containsAny(text, c.Phrases) {
+			ret = append(ret, c.ID)
+		}
+	}
+	if strings.Contains(text, bsdPhrase) {
+		if containsAny(text, bsd3ClausePhrases) {
+			ret = append(ret, "BSD-3-Clause")
+		} else {
+			ret = append(ret, "BSD-2-Clause")
+		}
+	}
+	return ret
+}
+
+// Compare returns a description of how the declared licences differ from those detected, or the empty string if
+// they agree. Nothing is reported if we couldn't detect anything.
+func Compare(declared, detected []string) string {
+	if len(detected) == 0 {
+		return ""
+	}
+	var missing, extra []string
+	for _, l := range detected {
+		if !slices.Contains(declared, l) {
+			missing = append(missing, l)
+		}
+	}
+	for _, l := range declared {
+		if !slices.Contains(detected, l) {
+			extra = append(extra, l)
+		}
+	}
+	if len(missing) == 0 && len(extra) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("declared licences [%s] don't match those detected [%s]", strings.Join(declared, ", "), strings.Join(detected, ", "))
+}
+
+func isLicenceFile(name string) bool {
+	name = strings.ToLower(name)
+	for _, prefix := range filePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalise lowercases the text and collapses anything that isn't a letter or a digit into single spaces, so that
+// line wrapping, punctuation and comment markers don't affect matching.
+func normalise(text string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
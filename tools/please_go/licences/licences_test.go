@@ -0,0 +1,90 @@
+package licences
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name: "BSD 3 clause",
+			text: `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+   * Neither the name of Google Inc. nor the names of its
+contributors may be used to endorse or promote products derived from
+this software without specific prior written permission.`,
+			expected: []string{"BSD-3-Clause"},
+		},
+		{
+			name: "BSD 2 clause",
+			text: `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+1. Redistributions of source code must retain the above copyright notice.`,
+			expected: []string{"BSD-2-Clause"},
+		},
+		{
+			name: "ISC",
+			text: `Permission to use, copy, modify, and/or distribute this software for any
+purpose with or without fee is hereby granted, provided that the above
+copyright notice and this permission notice appear in all copies.`,
+			expected: []string{"ISC"},
+		},
+		{
+			name: "LGPL isn't mistaken for GPL",
+			text: `                   GNU LESSER GENERAL PUBLIC LICENSE
+                       Version 3, 29 June 2007
+  This version of the GNU Lesser General Public License incorporates
+the terms and conditions of version 3 of the GNU General Public
+License, supplemented by the additional permissions listed below.`,
+			expected: []string{"LGPL-3.0"},
+		},
+		{
+			name: "MPL",
+			text: `Mozilla Public License Version 2.0
+==================================`,
+			expected: []string{"MPL-2.0"},
+		},
+		{
+			name: "unknown",
+			text: "All rights reserved.",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, Classify(test.text))
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	t.Run("single licence", func(t *testing.T) {
+		licences, err := Detect("tools/please_go/licences/test_data/mit")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"MIT"}, licences)
+	})
+
+	t.Run("multiple licence files", func(t *testing.T) {
+		licences, err := Detect("tools/please_go/licences/test_data/dual")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Apache-2.0", "MIT"}, licences)
+	})
+
+	t.Run("no licence files", func(t *testing.T) {
+		licences, err := Detect("tools/please_go/licences/test_data/none")
+		require.NoError(t, err)
+		assert.Empty(t, licences)
+	})
+}
+
+func TestCompare(t *testing.T) {
+	assert.Equal(t, "", Compare([]string{"MIT"}, []string{"MIT"}))
+	assert.Equal(t, "", Compare([]string{"MIT"}, nil))
+	assert.Equal(t, "declared licences [MIT] don't match those detected [Apache-2.0, MIT]", Compare([]string{"MIT"}, []string{"Apache-2.0", "MIT"}))
+}
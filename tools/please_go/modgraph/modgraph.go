@@ -0,0 +1,234 @@
+// Package modgraph builds the package import graph across first-party targets and the third-party modules generated by
+// go_repo, to work out why a module is part of the build, much like `go mod why`.
+package modgraph
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	bazelbuild "github.com/bazelbuild/buildtools/build"
+	"golang.org/x/mod/module"
+
+	"github.com/please-build/go-rules/tools/please_go/generate/gomoddeps"
+	"github.com/please-build/go-rules/tools/please_go/generate/manifest"
+)
+
+// goRuleKinds are the kinds of first-party rule we read Go sources from
+var goRuleKinds = []string{"go_library", "go_binary", "go_test", "cgo_library"}
+
+// A Node is a target in the graph, which compiles a single Go package.
+type Node struct {
+	Target     string   `json:"target"`
+	ImportPath string   `json:"import_path"`
+	Module     string   `json:"module"`
+	Imports    []string `json:"-"`
+	FirstParty bool     `json:"first_party,omitempty"`
+}
+
+// Graph is the import graph between first-party targets and the packages of third-party modules.
+type Graph struct {
+	// Nodes are all the targets in the graph, first-party ones first and then sorted by target
+	Nodes []*Node
+	// Versions are the versions of each third-party module
+	Versions map[string]string
+	// Requires are the modules each third-party module's go.mod requires
+	Requires map[string][]module.Version
+
+	packages map[string]*Node
+}
+
+// Load builds the graph from the first-party BUILD files under root and the manifests written by go_repo at the roots
+// of the given subrepos. Import paths of first-party packages are derived from the module in the host go.mod.
+func Load(hostGoModPath, root string, buildFileNames, subrepos []string) (*Graph, error) {
+	host, err := gomoddeps.ReadGoMod(hostGoModPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", hostGoModPath, err)
+	}
+	g := &Graph{
+		Versions: map[string]string{},
+		Requires: map[string][]module.Version{},
+		packages: map[string]*Node{},
+	}
+	if err := g.loadFirstParty(host.Module, root, buildFileNames); err != nil {
+		return nil, err
+	}
+	for _, dir := range subrepos {
+		if err := g.loadSubrepo(dir); err != nil {
+			return nil, err
+		}
+	}
+	slices.SortStableFunc(g.Nodes, func(a, b *Node) int {
+		if a.FirstParty != b.FirstParty {
+			if a.FirstParty {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Target, b.Target)
+	})
+	return g, nil
+}
+
+// loadFirstParty adds a node for each Go rule in the BUILD files under root.
+func (g *Graph) loadFirstParty(hostModule, root string, buildFileNames []string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "plz-out") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !slices.Contains(buildFileNames, d.Name()) {
+			return nil
+		}
+		dir, err := filepath.Rel(root, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		return g.loadBuildFile(hostModule, root, filepath.ToSlash(dir), p)
+	})
+}
+
+func (g *Graph) loadBuildFile(hostModule, root, dir, buildFilePath string) error {
+	data, err := os.ReadFile(buildFilePath)
+	if err != nil {
+		return err
+	}
+	file, err := bazelbuild.ParseBuild(buildFilePath, data)
+	if err != nil {
+		return err
+	}
+	pkgDir := filepath.Join(root, dir)
+	importPath := hostModule
+	if dir != "." {
+		importPath = path.Join(hostModule, dir)
+	}
+	for _, rule := range file.Rules("") {
+		if !slices.Contains(goRuleKinds, rule.Kind()) {
+			continue
+		}
+		srcs, err := goSrcs(rule, pkgDir)
+		if err != nil {
+			return err
+		}
+		imports, err := parseImports(pkgDir, srcs)
+		if err != nil {
+			return fmt.Errorf("failed to read imports of //%s:%s: %w", dir, rule.Name(), err)
+		}
+		node := &Node{
+			Target:     buildLabel(dir, rule.Name()),
+			ImportPath: importPath,
+			Module:     hostModule,
+			Imports:    imports,
+			FirstParty: true,
+		}
+		g.Nodes = append(g.Nodes, node)
+		if _, present := g.packages[importPath]; !present && (rule.Kind() == "go_library" || rule.Kind() == "cgo_library") {
+			g.packages[importPath] = node
+		}
+	}
+	return nil
+}
+
+// goSrcs returns the Go sources of a rule. We can't evaluate globs, so if the sources aren't a literal list we assume
+// it's all the Go files in the package, with or without tests depending on the kind of rule.
+func goSrcs(rule *bazelbuild.Rule, pkgDir string) ([]string, error) {
+	srcs := append(rule.AttrStrings("srcs"), rule.AttrStrings("go_srcs")...)
+	if len(srcs) > 0 {
+		var goSrcs []string
+		for _, src := range srcs {
+			if strings.HasSuffix(src, ".go") && !strings.Contains(src, ":") {
+				goSrcs = append(goSrcs, src)
+			}
+		}
+		return goSrcs, nil
+	}
+	entries, err := os.ReadDir(pkgDir)
+	if err != nil {
+		return nil, err
+	}
+	isTest := rule.Kind() == "go_test"
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasSuffix(name, ".go") && strings.HasSuffix(name, "_test.go") == isTest {
+			srcs = append(srcs, name)
+		}
+	}
+	return srcs, nil
+}
+
+// parseImports returns the non-standard library packages imported by the given files
+func parseImports(dir string, srcs []string) ([]string, error) {
+	var imports []string
+	fset := token.NewFileSet()
+	for _, src := range srcs {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, src), nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range f.Imports {
+			importPath := strings.Trim(spec.Path.Value, `"`)
+			if !isStdlib(importPath) && !slices.Contains(imports, importPath) {
+				imports = append(imports, importPath)
+			}
+		}
+	}
+	slices.Sort(imports)
+	return imports, nil
+}
+
+// loadSubrepo adds a node for each package in the manifest of a generated subrepo, along with the requirements from
+// the module's go.mod.
+func (g *Graph) loadSubrepo(dir string) error {
+	m, err := manifest.Read(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest in %s: %w", dir, err)
+	}
+	g.Versions[m.Module] = m.Version
+	for _, pkg := range m.Packages {
+		node := &Node{
+			Target:     pkg.Target,
+			ImportPath: pkg.ImportPath,
+			Module:     m.Module,
+			Imports:    pkg.Imports,
+		}
+		g.Nodes = append(g.Nodes, node)
+		g.packages[pkg.ImportPath] = node
+	}
+	goModPath := filepath.Join(dir, "go.mod")
+	if _, err := os.Stat(goModPath); os.IsNotExist(err) {
+		return nil
+	}
+	goMod, err := gomoddeps.ReadGoMod(goModPath, true)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", goModPath, err)
+	}
+	for _, req := range goMod.Requires {
+		g.Requires[m.Module] = append(g.Requires[m.Module], req.Version)
+	}
+	return nil
+}
+
+func buildLabel(dir, name string) string {
+	if dir == "." {
+		return "//:" + name
+	}
+	return "//" + dir + ":" + name
+}
+
+// isStdlib returns true if the import path looks like it's from the standard library, i.e. its first element doesn't
+// contain a dot.
+func isStdlib(importPath string) bool {
+	first, _, _ := strings.Cut(importPath, "/")
+	return !strings.Contains(first, ".")
+}
@@ -0,0 +1,128 @@
+package modgraph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
+)
+
+const testData = "tools/please_go/modgraph/test_data"
+
+func loadTestGraph(t *testing.T) *Graph {
+	t.Helper()
+	g, err := Load(testData+"/repo/go.mod", testData+"/repo", []string{"BUILD.test"}, []string{
+		testData + "/subrepos/a",
+		testData + "/subrepos/b",
+		testData + "/subrepos/c",
+	})
+	require.NoError(t, err)
+	return g
+}
+
+func TestLoad(t *testing.T) {
+	g := loadTestGraph(t)
+	targets := make([]string, len(g.Nodes))
+	for i, node := range g.Nodes {
+		targets[i] = node.Target
+	}
+	assert.Equal(t, []string{
+		"//app:app",
+		"//lib:lib",
+		"//lib:lib_test",
+		"///third_party/go/example.com_a//pkg",
+		"///third_party/go/example.com_b//:b",
+		"///third_party/go/example.com_c//x",
+	}, targets)
+	assert.Equal(t, []string{"example.com/a/pkg", "example.com/host/lib"}, g.Nodes[0].Imports)
+	assert.Equal(t, []string{"example.com/c/x"}, g.Nodes[2].Imports)
+}
+
+func TestWhy(t *testing.T) {
+	result := loadTestGraph(t).Why("example.com/c")
+	assert.Equal(t, "v1.2.0", result.Version)
+	assert.Equal(t, []module.Version{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: "v1.1.0"},
+	}, result.RequiredBy)
+
+	chains := map[string][]string{}
+	for _, chain := range result.Chains {
+		for _, pkg := range chain.Packages {
+			chains[chain.Target] = append(chains[chain.Target], pkg.ImportPath)
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		"//app:app":      {"example.com/host/app", "example.com/a/pkg", "example.com/c/x"},
+		"//lib:lib_test": {"example.com/host/lib", "example.com/c/x"},
+	}, chains)
+}
+
+func TestWhyNotImported(t *testing.T) {
+	result := loadTestGraph(t).Why("example.com/unknown")
+	assert.Empty(t, result.Chains)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, result, "text"))
+	assert.Equal(t, "# example.com/unknown\n(no first-party targets import example.com/unknown)\n", buf.String())
+}
+
+func TestWrite(t *testing.T) {
+	result := loadTestGraph(t).Why("example.com/b")
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, result, "text"))
+		assert.Equal(t, `# example.com/b@v1.1.0
+//app:app
+    example.com/host/app //app:app
+    example.com/host/lib //lib:lib
+    example.com/b ///third_party/go/example.com_b//:b
+//lib:lib
+    example.com/host/lib //lib:lib
+    example.com/b ///third_party/go/example.com_b//:b
+`, buf.String())
+	})
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, result, "json"))
+		assert.JSONEq(t, `{
+  "module": "example.com/b",
+  "version": "v1.1.0",
+  "chains": [
+    {
+      "target": "//app:app",
+      "packages": [
+        {"target": "//app:app", "import_path": "example.com/host/app", "module": "example.com/host", "first_party": true},
+        {"target": "//lib:lib", "import_path": "example.com/host/lib", "module": "example.com/host", "first_party": true},
+        {"target": "///third_party/go/example.com_b//:b", "import_path": "example.com/b", "module": "example.com/b"}
+      ]
+    },
+    {
+      "target": "//lib:lib",
+      "packages": [
+        {"target": "//lib:lib", "import_path": "example.com/host/lib", "module": "example.com/host", "first_party": true},
+        {"target": "///third_party/go/example.com_b//:b", "import_path": "example.com/b", "module": "example.com/b"}
+      ]
+    }
+  ]
+}`, buf.String())
+	})
+	t.Run("dot", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, result, "dot"))
+		assert.Equal(t, `digraph "example.com/b" {
+  "///third_party/go/example.com_b//:b" [label="example.com/b\n///third_party/go/example.com_b//:b"];
+  "//app:app" [label="example.com/host/app\n//app:app"];
+  "//lib:lib" [label="example.com/host/lib\n//lib:lib"];
+  "//app:app" -> "//lib:lib";
+  "//lib:lib" -> "///third_party/go/example.com_b//:b";
+}
+`, buf.String())
+	})
+	t.Run("unknown", func(t *testing.T) {
+		assert.ErrorContains(t, Write(&bytes.Buffer{}, result, "yaml"), "unknown format yaml")
+	})
+}
@@ -0,0 +1,87 @@
+package modgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Formats are the output formats Write supports
+var Formats = []string{"text", "json", "dot"}
+
+// Write writes the result in the given format.
+func Write(w io.Writer, result *Result, format string) error {
+	switch format {
+	case "text":
+		return writeText(w, result)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case "dot":
+		return writeDOT(w, result)
+	}
+	return fmt.Errorf("unknown format %s, must be one of %s", format, strings.Join(Formats, ", "))
+}
+
+// writeText writes the result in a similar format to `go mod why`.
+func writeText(w io.Writer, result *Result) error {
+	fmt.Fprintf(w, "# %s\n", moduleString(result.Module, result.Version))
+	if len(result.RequiredBy) > 0 {
+		requiredBy := make([]string, len(result.RequiredBy))
+		for i, mod := range result.RequiredBy {
+			requiredBy[i] = moduleString(mod.Path, mod.Version)
+		}
+		fmt.Fprintf(w, "# required by %s\n", strings.Join(requiredBy, ", "))
+	}
+	if len(result.Chains) == 0 {
+		_, err := fmt.Fprintf(w, "(no first-party targets import %s)\n", result.Module)
+		return err
+	}
+	for _, chain := range result.Chains {
+		fmt.Fprintln(w, chain.Target)
+		for _, pkg := range chain.Packages {
+			fmt.Fprintf(w, "    %s %s\n", pkg.ImportPath, pkg.Target)
+		}
+	}
+	return nil
+}
+
+// writeDOT writes the import chains as a graphviz graph between targets.
+func writeDOT(w io.Writer, result *Result) error {
+	labels := map[string]string{}
+	var edges []string
+	for _, chain := range result.Chains {
+		for i, pkg := range chain.Packages {
+			labels[pkg.Target] = pkg.ImportPath
+			if i > 0 {
+				edges = append(edges, fmt.Sprintf("  %q -> %q;\n", chain.Packages[i-1].Target, pkg.Target))
+			}
+		}
+	}
+	targets := make([]string, 0, len(labels))
+	for target := range labels {
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+	slices.Sort(edges)
+
+	fmt.Fprintf(w, "digraph %q {\n", result.Module)
+	for _, target := range targets {
+		fmt.Fprintf(w, "  %q [label=%q];\n", target, labels[target]+"\n"+target)
+	}
+	for _, edge := range slices.Compact(edges) {
+		fmt.Fprint(w, edge)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func moduleString(path, version string) string {
+	if version == "" {
+		return path
+	}
+	return path + "@" + version
+}
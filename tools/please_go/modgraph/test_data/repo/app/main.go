@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+
+	"example.com/a/pkg"
+	"example.com/host/lib"
+)
+
+func main() {
+	fmt.Println(pkg.Name(), lib.Name())
+}
@@ -0,0 +1,9 @@
+module example.com/host
+
+go 1.22
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.1.0
+	example.com/c v1.2.0
+)
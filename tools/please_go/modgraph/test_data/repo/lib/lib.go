@@ -0,0 +1,7 @@
+package lib
+
+import "example.com/b"
+
+func Name() string {
+	return b.Name()
+}
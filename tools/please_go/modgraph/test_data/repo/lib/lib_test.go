@@ -0,0 +1,13 @@
+package lib
+
+import (
+	"testing"
+
+	"example.com/c/x"
+)
+
+func TestName(t *testing.T) {
+	if Name() != x.Name() {
+		t.Fail()
+	}
+}
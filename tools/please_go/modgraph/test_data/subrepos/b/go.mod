@@ -0,0 +1,5 @@
+module example.com/b
+
+go 1.22
+
+require example.com/c v1.2.0
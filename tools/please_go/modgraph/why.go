@@ -0,0 +1,69 @@
+package modgraph
+
+import (
+	"golang.org/x/mod/module"
+)
+
+// Result explains why a module is part of the build.
+type Result struct {
+	Module  string `json:"module"`
+	Version string `json:"version,omitempty"`
+	// RequiredBy are the third-party modules whose go.mod requires this one
+	RequiredBy []module.Version `json:"required_by,omitempty"`
+	// Chains are the shortest import chains from each first-party target that depends on a package in the module
+	Chains []Chain `json:"chains"`
+}
+
+// A Chain is a sequence of packages from a first-party target to a package in the module, each importing the next.
+type Chain struct {
+	Target   string  `json:"target"`
+	Packages []*Node `json:"packages"`
+}
+
+// Why finds the first-party targets that depend on a package in the module, and the import chain through which they
+// do, much like `go mod why -m`.
+func (g *Graph) Why(mod string) *Result {
+	result := &Result{Module: mod, Version: g.Versions[mod], Chains: []Chain{}}
+	for from, reqs := range g.Requires {
+		for _, req := range reqs {
+			if req.Path == mod {
+				result.RequiredBy = append(result.RequiredBy, module.Version{Path: from, Version: g.Versions[from]})
+			}
+		}
+	}
+	module.Sort(result.RequiredBy)
+	for _, node := range g.Nodes {
+		if !node.FirstParty {
+			continue
+		}
+		if chain := g.shortestChain(node, mod); chain != nil {
+			result.Chains = append(result.Chains, Chain{Target: node.Target, Packages: chain})
+		}
+	}
+	return result
+}
+
+// shortestChain does a breadth first search from the node through its imports to a package in the module.
+func (g *Graph) shortestChain(from *Node, mod string) []*Node {
+	previous := map[*Node]*Node{from: nil}
+	queue := []*Node{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if !node.FirstParty && node.Module == mod {
+			var chain []*Node
+			for ; node != nil; node = previous[node] {
+				chain = append([]*Node{node}, chain...)
+			}
+			return chain
+		}
+		for _, i := range node.Imports {
+			next, present := g.packages[i]
+			if _, seen := previous[next]; present && !seen {
+				previous[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
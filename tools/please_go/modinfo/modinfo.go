@@ -52,11 +52,8 @@ func WriteModInfo(goTool, modulePath, pkgPath, buildMode, cgoEnabled, goos, goar
 		mod := strings.TrimSpace(string(contents))
 		if _, present := seen[mod]; !present {
 			seen[mod] = struct{}{}
-			if module, version, found := strings.Cut(mod, "@"); found {
-				bi.Deps = append(bi.Deps, &debug.Module{
-					Path:    module,
-					Version: version,
-				})
+			if dep := parseModule(mod); dep != nil {
+				bi.Deps = append(bi.Deps, dep)
 			}
 		}
 		return nil
@@ -67,6 +64,28 @@ func WriteModInfo(goTool, modulePath, pkgPath, buildMode, cgoEnabled, goos, goar
 	return os.WriteFile(outputFile, []byte(fmt.Sprintf("modinfo %q\n", modInfoData(bi.String()))), 0644)
 }
 
+// parseModule parses a module from a modinfo file, which is either module@version or, if the module has been replaced,
+// module@version => replacement@version like the go command reports them.
+func parseModule(mod string) *debug.Module {
+	mod, replacement, replaced := strings.Cut(mod, " => ")
+	module, version, found := strings.Cut(mod, "@")
+	if !found && !replaced {
+		return nil
+	}
+	dep := &debug.Module{
+		Path:    module,
+		Version: version,
+	}
+	if replaced {
+		path, version, _ := strings.Cut(replacement, "@")
+		dep.Replace = &debug.Module{
+			Path:    path,
+			Version: version,
+		}
+	}
+	return dep
+}
+
 // modInfoData wraps the given string in Go's modinfo. This mimics what go build does in order
 // for `go version` to be able to find this lot later on.
 func modInfoData(modinfo string) string {
@@ -0,0 +1,18 @@
+package modinfo
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseModule(t *testing.T) {
+	assert.Equal(t, &debug.Module{Path: "example.com/foo", Version: "v1.2.3"}, parseModule("example.com/foo@v1.2.3"))
+	assert.Equal(t, &debug.Module{
+		Path:    "example.com/foo",
+		Version: "v1.2.3",
+		Replace: &debug.Module{Path: "example.com/fork", Version: "v1.4.0"},
+	}, parseModule("example.com/foo@v1.2.3 => example.com/fork@v1.4.0"))
+	assert.Nil(t, parseModule("example.com/foo"))
+}
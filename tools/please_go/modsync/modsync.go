@@ -0,0 +1,189 @@
+// Package modsync keeps the go_repo rules in a third party BUILD file in sync with the host repo's go.mod.
+package modsync
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	bazelbuild "github.com/bazelbuild/buildtools/build"
+	bazeledit "github.com/bazelbuild/buildtools/edit"
+	"golang.org/x/mod/module"
+
+	"github.com/please-build/go-rules/tools/please_go/generate"
+	"github.com/please-build/go-rules/tools/please_go/generate/gomoddeps"
+)
+
+// legacyReplaceLabel labels the go_mod_download and go_repo rules that replaced modules were written as before go_repo
+// took a replace argument. Sync rewrites them to use replace instead.
+const legacyReplaceLabel = "go_replace_directive"
+
+// downloadAttrs are the arguments of a go_mod_download that go_repo also takes, and passes on to the go_mod_download it
+// creates.
+var downloadAttrs = []string{"licences", "patch", "strip"}
+
+// Sync creates, updates or removes the go_repo rules in the given BUILD file so that there's one for each module
+// required by the go.mod, with the replace argument set for any that the go.mod replaces. Only the module, version and
+// replace arguments of existing rules are changed so anything else set on them, e.g. licences or patches, is kept.
+// Replacements written as a go_mod_download labelled go_replace_directive are rewritten to use replace, and any
+// licences, patches or strip set on the go_mod_download are moved onto the go_repo.
+func Sync(goModPath, buildFilePath string) error {
+	goMod, err := gomoddeps.ReadGoMod(goModPath, false)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", goModPath, err)
+	}
+	buildFile, err := parseBuildFile(buildFilePath)
+	if err != nil {
+		return err
+	}
+
+	s := &syncer{
+		file:      buildFile,
+		repos:     map[string]*bazelbuild.Rule{},
+		downloads: map[string]*bazelbuild.Rule{},
+	}
+	for _, rule := range buildFile.Rules("go_repo") {
+		if mod := rule.AttrString("module"); mod != "" {
+			s.repos[mod] = rule
+		}
+	}
+	for _, rule := range buildFile.Rules("go_mod_download") {
+		s.downloads[rule.Name()] = rule
+	}
+
+	required := map[string]bool{}
+	for _, req := range goMod.Requires {
+		required[req.Path] = true
+		if goMod.Excluded(req.Version) {
+			log.Printf("warning: %s is required but excluded by %s", req.Version, goModPath)
+			continue
+		}
+		replacement, replaced := goMod.Replacement(req.Version)
+		if !replaced {
+			s.syncRepo(req.Version)
+		} else if replacement.Version == "" {
+			log.Printf("warning: %s is replaced by the local directory %s, which can't be synced", req.Version, replacement.Path)
+		} else {
+			s.syncReplacedRepo(req.Version, replacement)
+		}
+	}
+	for mod, rule := range s.repos {
+		if !required[mod] {
+			s.removeRepo(rule)
+		}
+	}
+	return saveBuildFile(buildFile)
+}
+
+type syncer struct {
+	file      *bazelbuild.File
+	repos     map[string]*bazelbuild.Rule
+	downloads map[string]*bazelbuild.Rule
+}
+
+// syncRepo makes sure there's a go_repo for the module that downloads the given version
+func (s *syncer) syncRepo(mod module.Version) {
+	rule := s.repo(mod.Path)
+	s.migrateDownload(rule)
+	rule.DelAttr("replace")
+	rule.SetAttr("version", generate.NewStringExpr(mod.Version))
+}
+
+// syncReplacedRepo makes sure there's a go_repo for the module that downloads its replacement instead
+func (s *syncer) syncReplacedRepo(mod, replacement module.Version) {
+	rule := s.repo(mod.Path)
+	s.migrateDownload(rule)
+	if rule.Attr("download") != nil {
+		log.Printf("warning: %s has its own download, so its replacement by %s can't be synced", mod.Path, replacement)
+		return
+	}
+	rule.SetAttr("version", generate.NewStringExpr(mod.Version))
+	rule.SetAttr("replace", generate.NewStringExpr(replacement.String()))
+}
+
+// migrateDownload removes the go_mod_download a replaced go_repo used to be written with, if it has one. Anything set
+// on the download that the go_repo can do itself is moved onto it so it isn't lost.
+func (s *syncer) migrateDownload(rule *bazelbuild.Rule) {
+	download := s.download(rule)
+	if download == nil || !slices.Contains(download.AttrStrings("labels"), legacyReplaceLabel) {
+		return
+	}
+	for _, attr := range downloadAttrs {
+		if value := download.Attr(attr); value != nil && rule.Attr(attr) == nil {
+			rule.SetAttr(attr, value)
+		}
+	}
+	s.deleteRule(download)
+	rule.DelAttr("download")
+	removeLabel(rule, legacyReplaceLabel)
+}
+
+// removeRepo removes a go_repo, and the go_mod_download it uses if it's in this file
+func (s *syncer) removeRepo(rule *bazelbuild.Rule) {
+	if download := s.download(rule); download != nil {
+		s.deleteRule(download)
+	}
+	s.deleteRule(rule)
+}
+
+// repo returns the go_repo rule for the module, adding one to the file if there isn't one already
+func (s *syncer) repo(mod string) *bazelbuild.Rule {
+	if rule, present := s.repos[mod]; present {
+		return rule
+	}
+	rule, _ := bazeledit.ExprToRule(&bazelbuild.CallExpr{X: &bazelbuild.Ident{Name: "go_repo"}}, "go_repo")
+	rule.SetAttr("module", generate.NewStringExpr(mod))
+	s.file.Stmt = append(s.file.Stmt, rule.Call)
+	s.repos[mod] = rule
+	return rule
+}
+
+// download returns the go_mod_download in this file that the go_repo uses, if there is one
+func (s *syncer) download(rule *bazelbuild.Rule) *bazelbuild.Rule {
+	download := rule.AttrString("download")
+	if !strings.HasPrefix(download, ":") {
+		return nil
+	}
+	return s.downloads[strings.TrimPrefix(download, ":")]
+}
+
+func (s *syncer) deleteRule(rule *bazelbuild.Rule) {
+	for i, stmt := range s.file.Stmt {
+		if stmt == rule.Call {
+			s.file.Stmt = append(s.file.Stmt[:i], s.file.Stmt[i+1:]...)
+			break
+		}
+	}
+	delete(s.downloads, rule.Name())
+}
+
+func removeLabel(rule *bazelbuild.Rule, label string) {
+	var labels []string
+	for _, l := range rule.AttrStrings("labels") {
+		if l != label {
+			labels = append(labels, l)
+		}
+	}
+	if len(labels) == 0 {
+		rule.DelAttr("labels")
+	} else {
+		rule.SetAttr("labels", generate.NewStringList(labels))
+	}
+}
+
+// parseBuildFile parses the BUILD file at the given path, or returns an empty one if it doesn't exist yet.
+func parseBuildFile(path string) (*bazelbuild.File, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return bazelbuild.ParseBuild(path, nil)
+	} else if err != nil {
+		return nil, err
+	}
+	return bazelbuild.ParseBuild(path, data)
+}
+
+func saveBuildFile(buildFile *bazelbuild.File) error {
+	return os.WriteFile(buildFile.Path, bazelbuild.Format(buildFile), 0644)
+}
@@ -0,0 +1,50 @@
+package modsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	before, err := os.ReadFile("tools/please_go/modsync/test_data/before.build")
+	require.NoError(t, err)
+	buildFile := filepath.Join(t.TempDir(), "BUILD")
+	require.NoError(t, os.WriteFile(buildFile, before, 0644))
+
+	require.NoError(t, Sync("tools/please_go/modsync/test_data/go_mod", buildFile))
+
+	expected, err := os.ReadFile("tools/please_go/modsync/test_data/expected.build")
+	require.NoError(t, err)
+	actual, err := os.ReadFile(buildFile)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+}
+
+func TestSyncNewFile(t *testing.T) {
+	buildFile := filepath.Join(t.TempDir(), "BUILD")
+	require.NoError(t, Sync("tools/please_go/modsync/test_data/go_mod", buildFile))
+
+	actual, err := os.ReadFile(buildFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(actual), "go_repo(\n    module = \"example.com/foo\",\n    version = \"v1.2.0\",\n)\n")
+	assert.Contains(t, string(actual), "go_repo(\n    module = \"example.com/fork\",\n    replace = \"example.com/someone/fork@v1.1.1\",\n    version = \"v1.1.0\",\n)\n")
+	assert.NotContains(t, string(actual), "go_mod_download")
+	assert.NotContains(t, string(actual), "example.com/local")
+}
+
+func TestSyncIsIdempotent(t *testing.T) {
+	expected, err := os.ReadFile("tools/please_go/modsync/test_data/expected.build")
+	require.NoError(t, err)
+	buildFile := filepath.Join(t.TempDir(), "BUILD")
+	require.NoError(t, os.WriteFile(buildFile, expected, 0644))
+
+	require.NoError(t, Sync("tools/please_go/modsync/test_data/go_mod", buildFile))
+
+	actual, err := os.ReadFile(buildFile)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+}
@@ -0,0 +1,174 @@
+// Package mvs implements minimal version selection over module requirements, as described in
+// https://research.swtch.com/vgo-mvs, to work out the build list the go tool would use.
+package mvs
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/please-build/go-rules/tools/please_go/generate/gomoddeps"
+)
+
+// Reqs returns the modules that a module version requires. Modules we know nothing about should return no requirements.
+type Reqs func(mod module.Version) ([]module.Version, error)
+
+// An Upgrade is a module whose version required by the target is below the one MVS selects.
+type Upgrade struct {
+	Path     string
+	Pinned   string
+	Selected string
+	// RequiredBy are the module versions that require something newer than the pinned version
+	RequiredBy []module.Version
+}
+
+func (u Upgrade) String() string {
+	requiredBy := make([]string, len(u.RequiredBy))
+	for i, mod := range u.RequiredBy {
+		requiredBy[i] = mod.String()
+	}
+	return fmt.Sprintf("%s is pinned at %s but %s is required by %s", u.Path, u.Pinned, u.Selected, strings.Join(requiredBy, ", "))
+}
+
+// graph is the requirement graph reachable from the target
+type graph struct {
+	selected map[string]string
+	// requiredBy records who requires each module version
+	requiredBy map[module.Version][]module.Version
+}
+
+// BuildList returns the build list for the target: the target itself, followed by the maximum version of each module
+// reachable from it, sorted by module path.
+func BuildList(target module.Version, reqs Reqs) ([]module.Version, error) {
+	g, err := walk(target, reqs)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]module.Version, 0, len(g.selected))
+	for path, version := range g.selected {
+		if path != target.Path {
+			list = append(list, module.Version{Path: path, Version: version})
+		}
+	}
+	module.Sort(list)
+	return append([]module.Version{target}, list...), nil
+}
+
+// Upgrades returns the target's direct requirements that are below the version MVS selects for them, i.e. where one
+// of its dependencies needs a newer version of a module than the one the target pins.
+func Upgrades(target module.Version, reqs Reqs) ([]Upgrade, error) {
+	g, err := walk(target, reqs)
+	if err != nil {
+		return nil, err
+	}
+	pins, err := reqs(target)
+	if err != nil {
+		return nil, err
+	}
+	var upgrades []Upgrade
+	for _, pin := range pins {
+		selected := g.selected[pin.Path]
+		if semver.Compare(pin.Version, selected) >= 0 {
+			continue
+		}
+		upgrade := Upgrade{Path: pin.Path, Pinned: pin.Version, Selected: selected}
+		for mod, requiredBy := range g.requiredBy {
+			if mod.Path == pin.Path && semver.Compare(mod.Version, pin.Version) > 0 {
+				upgrade.RequiredBy = append(upgrade.RequiredBy, requiredBy...)
+			}
+		}
+		module.Sort(upgrade.RequiredBy)
+		upgrade.RequiredBy = slices.Compact(upgrade.RequiredBy)
+		upgrades = append(upgrades, upgrade)
+	}
+	return upgrades, nil
+}
+
+// walk visits every module version reachable from the target, recording the maximum version of each module.
+func walk(target module.Version, reqs Reqs) (*graph, error) {
+	g := &graph{
+		selected:   map[string]string{target.Path: target.Version},
+		requiredBy: map[module.Version][]module.Version{},
+	}
+	seen := map[module.Version]bool{target: true}
+	queue := []module.Version{target}
+	for len(queue) > 0 {
+		mod := queue[0]
+		queue = queue[1:]
+		required, err := reqs(mod)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get requirements of %s: %w", mod, err)
+		}
+		for _, req := range required {
+			g.requiredBy[req] = append(g.requiredBy[req], mod)
+			if selected, present := g.selected[req.Path]; !present || semver.Compare(req.Version, selected) > 0 {
+				g.selected[req.Path] = req.Version
+			}
+			if !seen[req] {
+				seen[req] = true
+				queue = append(queue, req)
+			}
+		}
+	}
+	return g, nil
+}
+
+// LoadReqs returns the target module of the host go.mod and the requirement graph formed by it and the go.mod files
+// of the modules it requires. Each module's go.mod is matched to the version the host pins it at.
+func LoadReqs(hostGoModPath string, goModPaths []string) (module.Version, Reqs, error) {
+	host, err := gomoddeps.ReadGoMod(hostGoModPath, false)
+	if err != nil {
+		return module.Version{}, nil, fmt.Errorf("failed to read %s: %w", hostGoModPath, err)
+	}
+	target := module.Version{Path: host.Module}
+	var pins []module.Version
+	pinned := map[string]string{}
+	replaced := map[string]string{}
+	for _, req := range host.Requires {
+		if host.Excluded(req.Version) {
+			continue
+		}
+		pins = append(pins, req.Version)
+		pinned[req.Path] = req.Version.Version
+		if replacement, ok := host.Replacement(req.Version); ok && replacement.Version != "" {
+			replaced[replacement.Path] = req.Path
+		}
+	}
+
+	requirements := map[module.Version][]module.Version{}
+	for _, path := range goModPaths {
+		goMod, err := gomoddeps.ReadGoMod(path, true)
+		if err != nil {
+			return module.Version{}, nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		modPath := goMod.Module
+		// Forks used as replacements may have changed the module path in their go.mod
+		if original, present := replaced[modPath]; present {
+			if _, present := pinned[modPath]; !present {
+				modPath = original
+			}
+		}
+		version, present := pinned[modPath]
+		if !present {
+			log.Printf("warning: %s is not required by %s, ignoring %s", modPath, hostGoModPath, path)
+			continue
+		}
+		mod := module.Version{Path: modPath, Version: version}
+		for _, req := range goMod.Requires {
+			// Exclusions in the host go.mod apply to the requirements of every module
+			if !host.Excluded(req.Version) {
+				requirements[mod] = append(requirements[mod], req.Version)
+			}
+		}
+	}
+	return target, func(mod module.Version) ([]module.Version, error) {
+		if mod == target {
+			return pins, nil
+		}
+		return requirements[mod], nil
+	}, nil
+}
@@ -0,0 +1,84 @@
+package mvs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
+)
+
+func v(path, version string) module.Version {
+	return module.Version{Path: path, Version: version}
+}
+
+// The example graph from https://research.swtch.com/vgo-mvs
+var exampleGraph = map[module.Version][]module.Version{
+	v("a", ""):       {v("b", "v1.2.0"), v("c", "v1.2.0")},
+	v("b", "v1.2.0"): {v("d", "v1.3.0")},
+	v("c", "v1.2.0"): {v("d", "v1.4.0")},
+	v("d", "v1.3.0"): {v("e", "v1.2.0")},
+	v("d", "v1.4.0"): {v("e", "v1.2.0")},
+	v("e", "v1.2.0"): {},
+}
+
+func reqs(mod module.Version) ([]module.Version, error) {
+	return exampleGraph[mod], nil
+}
+
+func TestBuildList(t *testing.T) {
+	list, err := BuildList(v("a", ""), reqs)
+	require.NoError(t, err)
+	assert.Equal(t, []module.Version{
+		v("a", ""),
+		v("b", "v1.2.0"),
+		v("c", "v1.2.0"),
+		v("d", "v1.4.0"),
+		v("e", "v1.2.0"),
+	}, list)
+}
+
+func TestUpgrades(t *testing.T) {
+	upgrades, err := Upgrades(v("a", ""), func(mod module.Version) ([]module.Version, error) {
+		if mod == v("a", "") {
+			return []module.Version{v("b", "v1.2.0"), v("c", "v1.2.0"), v("d", "v1.3.0")}, nil
+		}
+		return exampleGraph[mod], nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Upgrade{
+		{Path: "d", Pinned: "v1.3.0", Selected: "v1.4.0", RequiredBy: []module.Version{v("c", "v1.2.0")}},
+	}, upgrades)
+	assert.Equal(t, "d is pinned at v1.3.0 but v1.4.0 is required by c@v1.2.0", upgrades[0].String())
+}
+
+func TestLoadReqs(t *testing.T) {
+	target, reqs, err := LoadReqs("tools/please_go/mvs/test_data/host_go_mod", []string{
+		"tools/please_go/mvs/test_data/b_go_mod",
+		"tools/please_go/mvs/test_data/c_go_mod",
+		"tools/please_go/mvs/test_data/e_go_mod",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, v("example.com/host", ""), target)
+
+	// The fork's requirements are attributed to the module it replaces
+	e, err := reqs(v("example.com/e", "v1.0.0"))
+	require.NoError(t, err)
+	assert.Equal(t, []module.Version{v("example.com/c", "v1.2.0")}, e)
+
+	list, err := BuildList(target, reqs)
+	require.NoError(t, err)
+	assert.Equal(t, []module.Version{
+		v("example.com/host", ""),
+		v("example.com/b", "v1.2.0"),
+		v("example.com/c", "v1.2.0"),
+		v("example.com/d", "v1.4.0"),
+		v("example.com/e", "v1.0.0"),
+		v("example.com/f", "v1.1.0"),
+	}, list)
+
+	upgrades, err := Upgrades(target, reqs)
+	require.NoError(t, err)
+	require.Len(t, upgrades, 1)
+	assert.Equal(t, "example.com/d is pinned at v1.3.0 but v1.4.0 is required by example.com/c@v1.2.0", upgrades[0].String())
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -8,16 +9,62 @@ import (
 	"strings"
 
 	"github.com/peterebden/go-cli-init/v5/flags"
+	"github.com/please-build/go-rules/tools/please_go/benchmark"
 	"github.com/please-build/go-rules/tools/please_go/cover"
+	"github.com/please-build/go-rules/tools/please_go/download"
 	"github.com/please-build/go-rules/tools/please_go/embed"
 	"github.com/please-build/go-rules/tools/please_go/filter"
 	"github.com/please-build/go-rules/tools/please_go/generate"
 	"github.com/please-build/go-rules/tools/please_go/install"
+	"github.com/please-build/go-rules/tools/please_go/licences"
+	"github.com/please-build/go-rules/tools/please_go/modgraph"
 	"github.com/please-build/go-rules/tools/please_go/modinfo"
+	"github.com/please-build/go-rules/tools/please_go/modsync"
+	"github.com/please-build/go-rules/tools/please_go/mvs"
 	"github.com/please-build/go-rules/tools/please_go/packageinfo"
 	"github.com/please-build/go-rules/tools/please_go/test"
+	"golang.org/x/mod/module"
 )
 
+// moduleSource are the options controlling where modules are downloaded from and how they're verified
+type moduleSource struct {
+	GoTool    string `short:"g" long:"go_tool" env:"TOOLS_GO" default:"go" description:"The go binary, used to fetch modules directly from version control"`
+	GoProxy   string `long:"goproxy" description:"The module proxies to download from, overriding GOPROXY"`
+	GoPrivate string `long:"goprivate" description:"Module path patterns to download directly and not verify against the checksum database, overriding GOPRIVATE"`
+	GoNoSumDB string `long:"gonosumdb" description:"Module path patterns not to verify against the checksum database, overriding GONOSUMDB"`
+	Netrc     string `long:"netrc" description:"A .netrc file with credentials for module proxies and private repos, overriding NETRC"`
+	Mirror    string `long:"mirror" description:"A module mirror directory to download from instead of any proxy"`
+	GoSum     string `long:"go_sum" description:"A go.sum file to verify modules against"`
+}
+
+// config returns the download configuration from the environment, overridden by any options that were given
+func (s moduleSource) config() download.Config {
+	config := download.ConfigFromEnv()
+	config.GoTool = mustResolvePath(s.GoTool)
+	if s.GoProxy != "" {
+		config.GoProxy = s.GoProxy
+	}
+	if s.Mirror != "" {
+		mirror, err := filepath.Abs(s.Mirror)
+		if err != nil {
+			log.Fatalf("failed to resolve mirror %s: %v", s.Mirror, err)
+		}
+		config.GoProxy = "file://" + filepath.ToSlash(mirror)
+	}
+	if s.GoPrivate != "" {
+		config.GoNoProxy = s.GoPrivate
+		config.GoNoSumDB = s.GoPrivate
+	}
+	if s.GoNoSumDB != "" {
+		config.GoNoSumDB = s.GoNoSumDB
+	}
+	if s.Netrc != "" {
+		config.Netrc = s.Netrc
+	}
+	config.GoSum = s.GoSum
+	return config
+}
+
 var opts = struct {
 	Usage string
 
@@ -38,14 +85,20 @@ var opts = struct {
 		} `positional-args:"true" required:"true"`
 	} `command:"install" alias:"i" description:"Compile a go module similarly to 'go install'"`
 	Test struct {
-		GoTool      string   `short:"g" long:"go_tool" description:"The location of the go binary" env:"TOOLS_GO" default:"go"`
-		Dir         string   `short:"d" long:"dir" description:"Directory to search for Go package files for coverage"`
-		Exclude     []string `short:"x" long:"exclude" default:"third_party/go" description:"Directories to exclude from search"`
-		Output      string   `short:"o" long:"output" description:"Output filename" required:"true"`
-		TestPackage string   `short:"t" long:"test_package" description:"The import path of the test package"`
-		Benchmark   bool     `short:"b" long:"benchmark" description:"Whether to run benchmarks instead of tests"`
-		External    bool     `long:"external" description:"Whether the test is external or not"`
-		Args        struct {
+		GoTool       string   `short:"g" long:"go_tool" description:"The go binary, whose version decides how packages are registered for coverage"`
+		Dir          string   `short:"d" long:"dir" description:"Directory to search for the import configs of packages to register for coverage"`
+		Exclude      []string `short:"x" long:"exclude" default:"third_party/go" description:"Directories to exclude from search"`
+		Output       string   `short:"o" long:"output" description:"Output filename" required:"true"`
+		TestPackage  string   `short:"t" long:"test_package" description:"The import path of the test package"`
+		XTestPackage string   `long:"xtest_package" description:"The import path of the external test package, for sources in a package with a _test suffix"`
+		Benchmark    bool     `short:"b" long:"benchmark" description:"Whether to run benchmarks instead of tests"`
+		External     bool     `long:"external" description:"Whether the test is external or not"`
+		Results      string   `long:"results_format" choice:"junit" description:"Write structured test results to $RESULTS_FILE in this format"`
+		ResultsOut   string   `long:"results_output" default:"test_results.go" description:"Output filename for the code to write structured test results"`
+		Watchdog     bool     `long:"watchdog" description:"Dump the stacks of all goroutines to $TEST_GOROUTINES_FILE shortly before the test times out"`
+		CheckLeaks   bool     `long:"check_leaks" description:"Fail if any goroutines are left running after the tests"`
+		DiagOut      string   `long:"diagnostics_output" default:"test_diagnostics.go" description:"Output filename for the code for the watchdog and leak check"`
+		Args         struct {
 			Sources []string `positional-arg-name:"sources" description:"Test source files" required:"true"`
 		} `positional-args:"true" required:"true"`
 	} `command:"testmain" alias:"t" description:"Generates a go main package to run the tests in a package."`
@@ -61,8 +114,9 @@ var opts = struct {
 		} `positional-args:"true"`
 	} `command:"cover" description:"Generates coverage information for a package."`
 	Filter struct {
-		Tags []string `short:"t" long:"tags" description:"Additional build tags to apply"`
-		Args struct {
+		Tags  []string `short:"t" long:"tags" description:"Additional build tags to apply"`
+		XTest string   `long:"xtest" choice:"exclude" choice:"only" description:"Exclude files in an external test package, or only include them"`
+		Args  struct {
 			Sources []string `positional-arg-name:"sources" description:"Source files to filter"`
 		} `positional-args:"true"`
 	} `command:"filter" alias:"f" description:"Filter go sources based on the go build tag rules."`
@@ -92,16 +146,82 @@ var opts = struct {
 		ModFile          string   `long:"mod_file" description:"Path to the host repo mod file to use to resolve dependencies against (dependencies will be resolved against the module as well if it exists)"`
 		Module           string   `long:"module" description:"The name of the current module"`
 		Version          string   `long:"version" description:"The version of the current module"`
+		Replace          string   `long:"replace" description:"The module@version the current module has been replaced with, if any"`
 		Install          []string `long:"install" description:"The packages to add to the :install alias"`
 		BuildTags        []string `long:"build_tag" description:"Any build tags to apply to the build"`
 		Subrepo          string   `long:"subrepo" description:"The subrepo root to output into"`
 		Licences         []string `long:"licence" description:"The licences under which the module is released"`
 		Labels           []string `long:"label" description:"Additional labels to attach to subrepo targets"`
 		LargePackages    []string `long:"large_package" description:"Relative names of packages which have lots of input files (meaning the go_library target should be marked as large)"`
+		IgnoredMains     bool     `long:"ignored_mains" description:"Generate go_binary targets for main packages excluded by the ignore build tag, e.g. code generators"`
 		Args             struct {
 			Requirements []string `positional-arg-name:"requirements" description:"Any module requirements not included in the go.mod"`
 		} `positional-args:"true"`
 	} `command:"generate" alias:"g" description:"Generate build targets for a Go module"`
+	Licences struct {
+		Declared []string `short:"d" long:"declared" description:"Licences declared for the module, to warn about if they don't match those detected"`
+		Args     struct {
+			Dir string `positional-arg-name:"dir" required:"true" description:"Root directory of the module"`
+		} `positional-args:"true" required:"true"`
+	} `command:"licences" description:"Detects the licences of a Go module from its licence files"`
+	Download struct {
+		Module  string   `short:"m" long:"module" required:"true" description:"The module to download"`
+		Version string   `long:"version" required:"true" description:"The version of the module to download"`
+		Out     string   `short:"o" long:"out" env:"OUT" required:"true" description:"The directory to extract the module into"`
+		Strip   []string `long:"strip" description:"Paths to remove from the module once downloaded"`
+		moduleSource
+		ModFile           string `long:"mod_file" description:"The host repo's go.mod, whose exclusions are checked when validating"`
+		Validate          bool   `long:"validate" description:"Check the module isn't excluded by the host go.mod"`
+		StrictRetractions bool   `long:"strict_retractions" description:"Fail, rather than warn, if the module has been retracted"`
+		Args              struct {
+			Patches []string `positional-arg-name:"patches" description:"Patches to apply to the module once downloaded"`
+		} `positional-args:"true"`
+	} `command:"download" alias:"d" description:"Downloads a third-party Go module from a module proxy"`
+	Mirror struct {
+		moduleSource
+		Out  string `short:"o" long:"out" env:"OUT" required:"true" description:"The directory to write the mirror to"`
+		Args struct {
+			Modules []string `positional-arg-name:"modules" description:"The modules to mirror, as module@version"`
+		} `positional-args:"true"`
+	} `command:"mirror" description:"Writes modules to a directory with the GOPROXY layout, to be used as an offline module proxy"`
+	Sync struct {
+		GoMod     string `short:"m" long:"go_mod" default:"go.mod" description:"The go.mod to sync rules from"`
+		BuildFile string `short:"b" long:"build_file" default:"third_party/go/BUILD" description:"The BUILD file containing the go_repo rules to update"`
+	} `command:"sync" description:"Updates the go_repo rules in a BUILD file to match the modules required by a go.mod"`
+	MVS struct {
+		ModFile string `short:"m" long:"mod_file" required:"true" description:"The host repo's go.mod"`
+		Check   string `long:"check" choice:"warn" choice:"error" description:"Warn or fail if a module pinned in the host go.mod is below the version another module requires"`
+		Args    struct {
+			GoMods []string `positional-arg-name:"go_mods" description:"The go.mod files of the modules required by the host"`
+		} `positional-args:"true"`
+	} `command:"mvs" description:"Runs minimal version selection over the host and module go.mod files and prints the build list"`
+	ModGraph struct {
+		Module     string   `long:"module" required:"true" description:"The module to explain the dependencies on"`
+		GoMod      string   `short:"m" long:"go_mod" default:"go.mod" description:"The host repo's go.mod, used to work out first-party import paths"`
+		Root       string   `short:"r" long:"root" default:"." description:"The root of the repo to find first-party BUILD files in"`
+		BuildFiles []string `long:"build_file_name" default:"BUILD" default:"BUILD.plz" description:"The names of BUILD files"`
+		Format     string   `short:"f" long:"format" default:"text" choice:"text" choice:"json" choice:"dot" description:"The output format"`
+		Args       struct {
+			Subrepos []string `positional-arg-name:"subrepos" description:"The generated go_repo subrepos to read manifests and go.mod files from"`
+		} `positional-args:"true"`
+	} `command:"modgraph" description:"Shows which first-party targets depend on a third-party module, and through which package imports"`
+	BenchFmt struct {
+		Text string `long:"text" description:"File to write the results to in the Go benchmark format"`
+		JSON string `long:"json" description:"File to write the results to as JSON"`
+		Args struct {
+			Inputs []string `positional-arg-name:"inputs" description:"Benchmark output to parse, read from stdin if not given"`
+		} `positional-args:"true"`
+	} `command:"benchfmt" description:"Parses the output of Go benchmarks into structured results"`
+	BenchStat struct {
+		Alpha     float64 `long:"alpha" default:"0.05" description:"The significance level below which differences are reported"`
+		Format    string  `short:"f" long:"format" default:"text" choice:"text" choice:"json" description:"The output format"`
+		Fail      bool    `long:"fail" description:"Exit unsuccessfully if any benchmark has significantly regressed"`
+		Threshold float64 `long:"threshold" description:"The percentage a benchmark has to regress by before --fail considers it"`
+		Args      struct {
+			Old string `positional-arg-name:"old" required:"true" description:"The old benchmark results, as benchmark output or JSON"`
+			New string `positional-arg-name:"new" required:"true" description:"The new benchmark results, as benchmark output or JSON"`
+		} `positional-args:"true" required:"true"`
+	} `command:"benchstat" description:"Compares two sets of benchmark results, showing statistically significant changes"`
 	ModInfo struct {
 		GoTool     string `short:"g" long:"go" env:"TOOLS_GO" required:"true" description:"The Go tool we'll use"`
 		ModulePath string `short:"m" long:"module_path" description:"The path for the module being built"`
@@ -149,7 +269,7 @@ var subCommands = map[string]func() int{
 		return 0
 	},
 	"testmain": func() int {
-		test.PleaseGoTest(opts.Test.GoTool, opts.Test.Dir, opts.Test.TestPackage, opts.Test.Output, opts.Test.Args.Sources, opts.Test.Exclude, opts.Test.Benchmark, opts.Test.External)
+		test.PleaseGoTest(opts.Test.GoTool, opts.Test.Dir, opts.Test.TestPackage, opts.Test.XTestPackage, opts.Test.Output, opts.Test.Args.Sources, opts.Test.Exclude, opts.Test.Benchmark, opts.Test.External, opts.Test.Results, opts.Test.ResultsOut, opts.Test.Watchdog, opts.Test.CheckLeaks, opts.Test.DiagOut)
 		return 0
 	},
 	"cover": func() int {
@@ -159,7 +279,7 @@ var subCommands = map[string]func() int{
 		return 0
 	},
 	"filter": func() int {
-		filter.Filter(opts.Filter.Tags, opts.Filter.Args.Sources)
+		filter.Filter(opts.Filter.Tags, opts.Filter.XTest, opts.Filter.Args.Sources)
 		return 0
 	},
 	"embed": func() int {
@@ -170,12 +290,132 @@ var subCommands = map[string]func() int{
 	},
 	"generate": func() int {
 		gen := opts.Generate
-		g := generate.New(gen.SrcRoot, gen.ThirdPartyFolder, gen.ModFile, gen.Module, gen.Version, gen.Subrepo, []string{"BUILD", "BUILD.plz"}, gen.Args.Requirements, gen.Install, gen.BuildTags, gen.Labels, gen.LargePackages, gen.Licences)
+		g := generate.New(gen.SrcRoot, gen.ThirdPartyFolder, gen.ModFile, gen.Module, gen.Version, gen.Replace, gen.Subrepo, []string{"BUILD", "BUILD.plz"}, gen.Args.Requirements, gen.Install, gen.BuildTags, gen.Labels, gen.LargePackages, gen.Licences, gen.IgnoredMains)
 		if err := g.Generate(); err != nil {
 			log.Fatalf("failed to generate go rules: %v", err)
 		}
 		return 0
 	},
+	"licences": func() int {
+		detected, err := licences.Detect(opts.Licences.Args.Dir)
+		if err != nil {
+			log.Fatalf("failed to detect licences: %s", err)
+		}
+		if len(opts.Licences.Declared) > 0 {
+			if msg := licences.Compare(opts.Licences.Declared, detected); msg != "" {
+				log.Printf("warning: %s", msg)
+			}
+		}
+		for _, l := range detected {
+			fmt.Println(l)
+		}
+		return 0
+	},
+	"download": func() int {
+		dl := opts.Download
+		config := dl.config()
+		config.ModFile = dl.ModFile
+		config.Validate = dl.Validate
+		config.StrictRetractions = dl.StrictRetractions
+		// Warnings go to stdout for go_mod_download to show them, since Please doesn't show the output of builds.
+		config.Warnings = os.Stdout
+		if err := download.Download(config, module.Version{Path: dl.Module, Version: dl.Version}, dl.Out, dl.Strip, dl.Args.Patches); err != nil {
+			log.Fatalf("failed to download module: %v", err)
+		}
+		return 0
+	},
+	"mirror": func() int {
+		mods := make([]module.Version, len(opts.Mirror.Args.Modules))
+		for i, mod := range opts.Mirror.Args.Modules {
+			path, version, found := strings.Cut(mod, "@")
+			if !found {
+				log.Fatalf("invalid module %s, must be module@version", mod)
+			}
+			mods[i] = module.Version{Path: path, Version: version}
+		}
+		if err := download.Mirror(opts.Mirror.config(), mods, opts.Mirror.Out); err != nil {
+			log.Fatalf("failed to mirror modules: %v", err)
+		}
+		return 0
+	},
+	"modgraph": func() int {
+		mg := opts.ModGraph
+		g, err := modgraph.Load(mg.GoMod, mg.Root, mg.BuildFiles, mg.Args.Subrepos)
+		if err != nil {
+			log.Fatalf("failed to load module graph: %v", err)
+		}
+		if err := modgraph.Write(os.Stdout, g.Why(mg.Module), mg.Format); err != nil {
+			log.Fatalf("failed to write module graph: %v", err)
+		}
+		return 0
+	},
+	"benchfmt": func() int {
+		results, err := benchmark.ReadOutput(opts.BenchFmt.Args.Inputs)
+		if err != nil {
+			log.Fatalf("failed to read benchmark results: %v", err)
+		}
+		if err := results.WriteFiles(opts.BenchFmt.Text, opts.BenchFmt.JSON); err != nil {
+			log.Fatalf("failed to write benchmark results: %v", err)
+		}
+		return 0
+	},
+	"benchstat": func() int {
+		bs := opts.BenchStat
+		comparisons, err := benchmark.CompareFiles(bs.Args.Old, bs.Args.New, bs.Alpha)
+		if err != nil {
+			log.Fatalf("failed to read benchmark results: %v", err)
+		}
+		if err := benchmark.WriteComparisons(os.Stdout, comparisons, bs.Format); err != nil {
+			log.Fatalf("failed to write comparison: %v", err)
+		}
+		if bs.Fail {
+			if err := benchmark.CheckRegressions(comparisons, bs.Threshold); err != nil {
+				log.Printf("error: %v", err)
+				return 1
+			}
+		}
+		return 0
+	},
+	"sync": func() int {
+		if err := modsync.Sync(opts.Sync.GoMod, opts.Sync.BuildFile); err != nil {
+			log.Fatalf("failed to sync go.mod: %v", err)
+		}
+		return 0
+	},
+	"mvs": func() int {
+		target, reqs, err := mvs.LoadReqs(opts.MVS.ModFile, opts.MVS.Args.GoMods)
+		if err != nil {
+			log.Fatalf("failed to load requirements: %v", err)
+		}
+		buildList, err := mvs.BuildList(target, reqs)
+		if err != nil {
+			log.Fatalf("failed to select versions: %v", err)
+		}
+		for _, mod := range buildList[1:] {
+			fmt.Println(mod.Path, mod.Version)
+		}
+		if opts.MVS.Check == "" {
+			return 0
+		}
+		upgrades, err := mvs.Upgrades(target, reqs)
+		if err != nil {
+			log.Fatalf("failed to select versions: %v", err)
+		}
+		if opts.MVS.Check == "warn" {
+			// These are printed without a timestamp, for go_repo to show them.
+			for _, upgrade := range upgrades {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", upgrade)
+			}
+			return 0
+		}
+		for _, upgrade := range upgrades {
+			log.Printf("error: %s", upgrade)
+		}
+		if len(upgrades) > 0 {
+			return 1
+		}
+		return 0
+	},
 	"package_info": func() int {
 		pi := opts.PackageInfo
 		if err := packageinfo.WritePackageInfo(pi.ImportPath, pi.Pkg, pi.ImportMap, pi.Subrepo, pi.Module, pi.IncludeTests, os.Stdout); err != nil {
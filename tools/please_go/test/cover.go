@@ -0,0 +1,74 @@
+package test
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FindCoverPackages returns the packages to register for coverage, like `go test -coverpkg` does. They're found from the
+// import configs of the test's dependencies under dir, apart from any in the excluded directories. Packages are
+// identified by import path, which is what they're instrumented for coverage with.
+func FindCoverPackages(dir string, exclude []string) ([]string, error) {
+	var pkgs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && isExcluded(dir, path, exclude) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".importconfig") {
+			return nil
+		}
+		importPaths, err := readImportConfig(path)
+		if err != nil {
+			return err
+		}
+		pkgs = append(pkgs, importPaths...)
+		return nil
+	})
+	slices.Sort(pkgs)
+	return slices.Compact(pkgs), err
+}
+
+// isExcluded returns true if the directory is one of the excluded ones, relative to the root of the search.
+func isExcluded(root, path string, exclude []string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, x := range exclude {
+		if x = strings.Trim(x, "/"); rel == x || strings.HasPrefix(rel, x+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readImportConfig returns the import paths of the packages in an import config file.
+func readImportConfig(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var importPaths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if directive, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "packagefile "); ok {
+			if importPath, _, ok := strings.Cut(directive, "="); ok {
+				importPaths = append(importPaths, importPath)
+			}
+		}
+	}
+	return importPaths, scanner.Err()
+}
@@ -0,0 +1,160 @@
+// Package diagnostics implements the watchdog and goroutine leak check behind go_test's watchdog and check_leaks
+// options. WriteTestMain writes this file out next to the test main when either is enabled, and the main calls
+// startTestWatchdog and checkGoroutineLeaks around m.Run().
+package diagnostics
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// testGoroutinesFileEnv is the file the watchdog dumps the stacks of all goroutines to.
+const testGoroutinesFileEnv = "TEST_GOROUTINES_FILE"
+
+// testTimeoutEnv is the timeout of the test in seconds, after which Please kills it.
+const testTimeoutEnv = "TEST_TIMEOUT"
+
+// testLeakRetryTime is how long checkGoroutineLeaks waits for goroutines to exit before deciding they've leaked.
+const testLeakRetryTime = time.Second
+
+// ignoredGoroutineFunctions are the functions of goroutines which are expected to still be running once the tests
+// have finished, e.g. those started by the testing or os/signal packages.
+var ignoredGoroutineFunctions = []string{
+	"testing.RunTests",
+	"testing.(*T).Run",
+	"testing.(*T).Parallel",
+	"testing.(*M).Run",
+	"testing.(*M).startAlarm",
+	"os/signal.signal_recv",
+	"os/signal.loop",
+	"runtime.goexit",
+	"runtime.ensureSigM",
+}
+
+// startTestWatchdog dumps the stacks of all goroutines to $TEST_GOROUTINES_FILE shortly before the test times out,
+// either from $TEST_TIMEOUT or -test.timeout, whichever is sooner. The file is created straight away, so it's always
+// there even if the test doesn't time out. It also makes panics print the stacks of all goroutines rather than just
+// the one that panicked. It returns a function to stop the watchdog.
+func startTestWatchdog() func() {
+	debug.SetTraceback("all")
+	filename := os.Getenv(testGoroutinesFileEnv)
+	if filename == "" {
+		return func() {}
+	}
+	if err := os.WriteFile(filename, nil, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create goroutine dump file: %s\n", err)
+		return func() {}
+	}
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	timeout := testWatchdogTimeout(os.Getenv(testTimeoutEnv), flag.Lookup("test.timeout"))
+	if timeout <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	timer := time.AfterFunc(timeout*9/10, func() {
+		if err := dumpGoroutines(filename, time.Since(start), timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to dump goroutines: %s\n", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "test is about to time out, wrote the stacks of all goroutines to %s\n", filename)
+	})
+	return func() { timer.Stop() }
+}
+
+// testWatchdogTimeout returns the sooner of the timeout in seconds from the environment and that from -test.timeout,
+// or zero if neither is set.
+func testWatchdogTimeout(env string, timeoutFlag *flag.Flag) time.Duration {
+	var timeout time.Duration
+	if seconds, err := strconv.Atoi(env); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeoutFlag != nil {
+		if getter, ok := timeoutFlag.Value.(flag.Getter); ok {
+			if d, ok := getter.Get().(time.Duration); ok && d > 0 && (timeout == 0 || d < timeout) {
+				timeout = d
+			}
+		}
+	}
+	return timeout
+}
+
+func dumpGoroutines(filename string, elapsed, timeout time.Duration) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "Goroutines after %s, with the test due to time out after %s:\n\n", elapsed.Round(time.Millisecond), timeout)
+	if _, err := f.WriteString(allGoroutineStacks()); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// allGoroutineStacks returns the stacks of all goroutines, starting with the current one.
+func allGoroutineStacks() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// checkGoroutineLeaks returns false, after printing their stacks, if any goroutines are still running other than the
+// current one and those we expect to be. Goroutines can take a moment to exit after a test finishes, so it waits a
+// little while for them to do so before deciding they've leaked.
+func checkGoroutineLeaks() bool {
+	deadline := time.Now().Add(testLeakRetryTime)
+	for delay := time.Millisecond; ; delay *= 2 {
+		leaked := leakedGoroutines(allGoroutineStacks())
+		if len(leaked) == 0 {
+			return true
+		} else if time.Now().After(deadline) {
+			fmt.Fprintf(os.Stderr, "found %d leaked goroutines:\n\n%s\n", len(leaked), strings.Join(leaked, "\n\n"))
+			return false
+		}
+		time.Sleep(delay)
+	}
+}
+
+// leakedGoroutines returns the stacks of goroutines in the output of runtime.Stack, other than the first, which is the
+// current one, and any running one of the ignored functions.
+func leakedGoroutines(stacks string) []string {
+	var leaked []string
+	for i, stack := range strings.Split(strings.TrimSpace(stacks), "\n\n") {
+		if i > 0 && !isIgnoredGoroutine(stack) {
+			leaked = append(leaked, stack)
+		}
+	}
+	return leaked
+}
+
+// isIgnoredGoroutine returns true if the goroutine is running one of the ignored functions, or is waiting in one.
+func isIgnoredGoroutine(stack string) bool {
+	for _, line := range strings.Split(stack, "\n")[1:] {
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "created by ") {
+			continue
+		}
+		// Function calls end with their arguments, e.g. "testing.(*T).Run(0xc000007a00, ...)"
+		if i := strings.LastIndexByte(line, '('); i > 0 {
+			line = line[:i]
+		}
+		for _, fn := range ignoredGoroutineFunctions {
+			if line == fn {
+				return true
+			}
+		}
+	}
+	return false
+}
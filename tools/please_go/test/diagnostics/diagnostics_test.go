@@ -0,0 +1,88 @@
+package diagnostics
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stacks = `goroutine 1 [running]:
+main.internalMain()
+	/tmp/main.go:120 +0x1d
+main.main()
+	/tmp/main.go:140 +0x25
+
+goroutine 6 [chan receive]:
+example.com/pkg.leak.func1()
+	/tmp/pkg/pkg.go:12 +0x2c
+created by example.com/pkg.leak in goroutine 5
+	/tmp/pkg/pkg.go:11 +0x7a
+
+goroutine 7 [syscall]:
+os/signal.signal_recv()
+	/usr/local/go/src/runtime/sigqueue.go:152 +0x29
+os/signal.loop()
+	/usr/local/go/src/os/signal/signal_unix.go:23 +0x13
+created by os/signal.Notify.func1.1 in goroutine 1
+	/usr/local/go/src/os/signal/signal.go:151 +0x1f
+
+goroutine 8 [chan receive]:
+testing.(*T).Parallel(0xc000007a00)
+	/usr/local/go/src/testing/testing.go:1484 +0x215
+example.com/pkg.TestParallel(0xc000007a00)
+	/tmp/pkg/pkg_test.go:8 +0x25
+`
+
+func TestLeakedGoroutines(t *testing.T) {
+	leaked := leakedGoroutines(stacks)
+	require.Len(t, leaked, 1)
+	assert.Contains(t, leaked[0], "goroutine 6 [chan receive]:")
+}
+
+func TestCheckGoroutineLeaks(t *testing.T) {
+	assert.True(t, checkGoroutineLeaks())
+
+	ch := make(chan struct{})
+	go func() { <-ch }()
+	assert.False(t, checkGoroutineLeaks())
+	close(ch)
+	assert.True(t, checkGoroutineLeaks())
+}
+
+func TestTestWatchdogTimeout(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	timeout := fs.Duration("test.timeout", 0, "")
+	assert.Equal(t, time.Duration(0), testWatchdogTimeout("", fs.Lookup("test.timeout")))
+	assert.Equal(t, time.Duration(0), testWatchdogTimeout("", nil))
+	assert.Equal(t, 60*time.Second, testWatchdogTimeout("60", fs.Lookup("test.timeout")))
+	*timeout = 55 * time.Second
+	assert.Equal(t, 55*time.Second, testWatchdogTimeout("60", fs.Lookup("test.timeout")))
+	assert.Equal(t, 55*time.Second, testWatchdogTimeout("", fs.Lookup("test.timeout")))
+	assert.Equal(t, 30*time.Second, testWatchdogTimeout("30", fs.Lookup("test.timeout")))
+}
+
+func TestStartTestWatchdog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "goroutines.txt")
+	t.Setenv(testGoroutinesFileEnv, filename)
+	t.Setenv(testTimeoutEnv, "1")
+	stop := startTestWatchdog()
+	defer stop()
+
+	// The file exists straight away, and has the stacks in once the watchdog fires.
+	contents, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Empty(t, contents)
+	assert.Eventually(t, func() bool {
+		contents, err := os.ReadFile(filename)
+		return err == nil && len(contents) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	contents, err = os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), "with the test due to time out after 1s")
+	assert.Contains(t, string(contents), "TestStartTestWatchdog")
+}
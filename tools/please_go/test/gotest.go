@@ -2,11 +2,39 @@ package test
 
 import (
 	"log"
+
+	"github.com/please-build/go-rules/tools/please_go/install/toolchain"
 )
 
-// PleaseGoTest will generate the test main for the provided sources
-func PleaseGoTest(goTool, dir, testPackage, output string, sources, exclude []string, isBenchmark, external bool) {
-	if err := WriteTestMain(testPackage, sources, output, isBenchmark); err != nil {
+// PleaseGoTest will generate the test main for the provided sources, which can include an external test package
+// imported from xtestPackage. If dir is given, the test's own package and the packages found under dir are registered
+// for coverage, in the way the version of goTool supports.
+func PleaseGoTest(goTool, dir, testPackage, xtestPackage, output string, sources, exclude []string, isBenchmark, external bool, resultsFormat, resultsOutput string, watchdog, checkLeaks bool, diagnosticsOutput string) {
+	var coverPackages []string
+	legacyCoverage := false
+	if dir != "" {
+		pkgs, err := FindCoverPackages(dir, exclude)
+		if err != nil {
+			log.Fatalf("Error finding packages for coverage: %s", err)
+		}
+		// An external test's own package isn't the one being tested, so there's nothing in it to cover.
+		if !external {
+			coverPackages = append(coverPackages, testPackage)
+		}
+		for _, pkg := range pkgs {
+			if pkg != testPackage {
+				coverPackages = append(coverPackages, pkg)
+			}
+		}
+		if goTool != "" {
+			version, err := toolchain.GoMinorVersion(goTool)
+			if err != nil {
+				log.Fatalf("Error getting the version of %s: %s", goTool, err)
+			}
+			legacyCoverage = version < 23
+		}
+	}
+	if err := WriteTestMain(testPackage, xtestPackage, sources, output, external, isBenchmark, coverPackages, legacyCoverage, resultsFormat, resultsOutput, watchdog, checkLeaks, diagnosticsOutput); err != nil {
 		log.Fatalf("Error writing test main: %s", err)
 	}
 }
@@ -0,0 +1,22 @@
+// Package shard assigns tests to shards. Everything after its imports is pasted into the test mains generated by
+// please_go, which import the same packages under the same names, so it may only depend on those.
+package shard
+
+import (
+	_gostdlib_fnv "hash/fnv"
+	_gostdlib_os "os"
+	_gostdlib_strconv "strconv"
+)
+
+// inTestShard returns true if the named test, example or fuzz target should run in this shard. When the tests are split
+// into shards, each one is assigned to a shard by a stable hash of its name.
+func inTestShard(name string) bool {
+	total, err := _gostdlib_strconv.Atoi(_gostdlib_os.Getenv("TEST_TOTAL_SHARDS"))
+	if err != nil || total <= 1 {
+		return true
+	}
+	index, _ := _gostdlib_strconv.Atoi(_gostdlib_os.Getenv("TEST_SHARD_INDEX"))
+	h := _gostdlib_fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32()%uint32(total)) == index
+}
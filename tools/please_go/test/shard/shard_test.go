@@ -0,0 +1,46 @@
+package shard
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInTestShardPartitionsTests(t *testing.T) {
+	names := []string{"Example", "ExampleT_Method", "FuzzParse"}
+	for i := 0; i < 100; i++ {
+		names = append(names, fmt.Sprintf("Test%d", i))
+	}
+	for _, total := range []int{2, 3, 7} {
+		t.Run(strconv.Itoa(total), func(t *testing.T) {
+			t.Setenv("TEST_TOTAL_SHARDS", strconv.Itoa(total))
+			shards := make([][]string, total)
+			for index := range shards {
+				t.Setenv("TEST_SHARD_INDEX", strconv.Itoa(index))
+				for _, name := range names {
+					if inTestShard(name) {
+						shards[index] = append(shards[index], name)
+					}
+				}
+			}
+			// Every name is in exactly one shard, and no shard is left empty.
+			var all []string
+			for index, shard := range shards {
+				assert.NotEmpty(t, shard, "shard %d", index)
+				all = append(all, shard...)
+			}
+			assert.ElementsMatch(t, names, all)
+		})
+	}
+}
+
+func TestInTestShardWithoutShards(t *testing.T) {
+	t.Setenv("TEST_TOTAL_SHARDS", "")
+	t.Setenv("TEST_SHARD_INDEX", "")
+	assert.True(t, inTestShard("TestFoo"))
+
+	t.Setenv("TEST_TOTAL_SHARDS", "1")
+	assert.True(t, inTestShard("TestFoo"))
+}
@@ -0,0 +1,8 @@
+package external_test
+
+import "fmt"
+
+func ExampleExternal() {
+	fmt.Println("external")
+	// Output: external
+}
@@ -0,0 +1,8 @@
+package external
+
+import "fmt"
+
+func ExampleInternal() {
+	fmt.Println("internal")
+	// Output: internal
+}
@@ -0,0 +1,41 @@
+package naming
+
+import "fmt"
+
+type T struct{}
+
+func (T) Method() string { return "method" }
+
+// An example of the package
+func Example() {
+	fmt.Println("package")
+	// Output: package
+}
+
+// A second example of the package, with a suffix
+func Example_second() {
+	fmt.Println("second")
+	// Output: second
+}
+
+func ExampleT() {
+	fmt.Println(T{})
+	// Output: {}
+}
+
+func ExampleT_Method() {
+	fmt.Println(T{}.Method())
+	// Output: method
+}
+
+func ExampleT_Method_suffix() {
+	fmt.Println(T{}.Method())
+	// Output: method
+}
+
+// These aren't examples at all; the suffix must start with a lower case letter and examples take no arguments
+func Examplelower() {}
+
+func ExampleArgs(s string) {
+	// Output:
+}
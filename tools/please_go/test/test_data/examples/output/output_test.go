@@ -0,0 +1,19 @@
+package output
+
+import "fmt"
+
+// Examples with output are run
+func ExampleHello() {
+	fmt.Println("hello")
+	// Output: hello
+}
+
+// So are examples with an empty output comment, which must print nothing
+func ExampleSilent() {
+	// Output:
+}
+
+// Examples without an output comment are compiled but not run
+func ExampleNoOutput() {
+	fmt.Println("not checked")
+}
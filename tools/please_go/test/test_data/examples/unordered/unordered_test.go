@@ -0,0 +1,20 @@
+package unordered
+
+import "fmt"
+
+func ExampleUnordered() {
+	for _, s := range map[string]bool{"a": true, "b": true} {
+		fmt.Println(s)
+	}
+	// Unordered output:
+	// true
+	// true
+}
+
+func ExampleOrdered() {
+	fmt.Println("a")
+	fmt.Println("b")
+	// Output:
+	// a
+	// b
+}
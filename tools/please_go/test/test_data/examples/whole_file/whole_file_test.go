@@ -0,0 +1,18 @@
+package whole_file_test
+
+import "fmt"
+
+// A whole file example has its own declarations alongside a single example.
+type shape interface {
+	area() float64
+}
+
+type square struct{ side float64 }
+
+func (s square) area() float64 { return s.side * s.side }
+
+func Example() {
+	var s shape = square{side: 2}
+	fmt.Println(s.area())
+	// Output: 4
+}
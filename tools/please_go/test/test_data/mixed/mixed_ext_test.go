@@ -0,0 +1,16 @@
+package mixed_test
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestExternal(t *testing.T) {}
+
+func ExampleExternal() {
+	// Output:
+}
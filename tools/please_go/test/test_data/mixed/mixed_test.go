@@ -0,0 +1,9 @@
+package mixed
+
+import "testing"
+
+func TestInternal(t *testing.T) {}
+
+func ExampleInternal() {
+	// Output:
+}
@@ -0,0 +1,336 @@
+// Package testresults converts the output of a Go test binary into structured results. Its source is copied into the
+// test mains generated by please_go, so it may only depend on the standard library.
+package testresults
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// testResultsChildEnv is set when a test binary reruns itself to run the tests, so that it does so rather than
+// rerunning itself again.
+const testResultsChildEnv = "PLEASE_GO_TEST_RESULTS_CHILD"
+
+// runTestsWithResults reruns the test binary with the same arguments to run the tests, converting its output into the
+// given format, which is currently only junit, and writing it to the results file. Running the tests in another process
+// means we still get results if they exit early, e.g. by calling os.Exit or panicking. The test output is passed
+// through as usual. It returns the exit code of the tests.
+func runTestsWithResults(pkg, format, resultsFile string) int {
+	converter := newTestConverter(pkg, os.Stdout)
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), testResultsChildEnv+"=1")
+	cmd.Stdin = os.Stdin
+	// Like go test, output on stderr is part of the test output, so panics are attributed to the test that caused them.
+	cmd.Stdout = converter
+	cmd.Stderr = converter
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to run tests: %s\n", err)
+		return 1
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			fmt.Fprintf(os.Stderr, "failed to run tests: %s\n", err)
+			return 1
+		}
+		if exitCode = exitErr.ExitCode(); exitCode <= 0 {
+			// The tests were killed by a signal
+			exitCode = 1
+		}
+	}
+	signal.Stop(signals)
+	close(signals)
+
+	if err := writeTestResults(format, resultsFile, converter.Close(exitCode == 0)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write test results: %s\n", err)
+		return 1
+	}
+	return exitCode
+}
+
+// writeTestResults writes the events to the results file in the given format.
+func writeTestResults(format, resultsFile string, events []testEvent) error {
+	f, err := os.Create(resultsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch format {
+	case "junit":
+		if _, err := io.WriteString(f, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(f)
+		enc.Indent("", "  ")
+		if err := enc.Encode(buildJUnitTestSuites(events)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown test results format %s", format)
+	}
+	return f.Close()
+}
+
+// testEvent is a test result event, in the same format as `go test -json` and `go tool test2json`.
+type testEvent struct {
+	Time    time.Time `json:",omitempty"`
+	Action  string
+	Package string  `json:",omitempty"`
+	Test    string  `json:",omitempty"`
+	Elapsed float64 `json:",omitempty"`
+	Output  string  `json:",omitempty"`
+}
+
+const (
+	// testFrameMarker precedes the lines the testing package writes to frame test output in -test.v=test2json mode
+	testFrameMarker = '\x16'
+	// testErrorStart and testErrorEnd surround the output of t.Error and friends in that mode
+	testErrorStart = '\x0f'
+	testErrorEnd   = '\x0e'
+)
+
+// testConverter parses the output of a test binary run with -test.v=test2json into events. The output is also passed
+// through to another writer as it would appear with -test.v.
+type testConverter struct {
+	pkg     string
+	text    io.Writer
+	start   time.Time
+	events  []testEvent
+	current string
+	running map[string]bool
+	partial []byte
+}
+
+func newTestConverter(pkg string, text io.Writer) *testConverter {
+	c := &testConverter{
+		pkg:     pkg,
+		text:    text,
+		start:   time.Now(),
+		running: map[string]bool{},
+	}
+	c.event(testEvent{Action: "start"})
+	return c
+}
+
+// Write handles a chunk of the test binary's output.
+func (c *testConverter) Write(b []byte) (int, error) {
+	c.partial = append(c.partial, b...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i == -1 {
+			break
+		}
+		c.handleLine(string(c.partial[:i+1]))
+		c.partial = c.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+// handleLine handles a single line of output, which may contain a frame after some output without a trailing newline.
+func (c *testConverter) handleLine(line string) {
+	output, frame, framed := strings.Cut(line, string(testFrameMarker))
+	if output = stripTestErrorMarkers(output); output != "" {
+		c.output(output)
+	}
+	if framed {
+		c.handleFrame(frame)
+	}
+}
+
+// handleFrame handles a line written by the testing package to describe the progress of a test.
+func (c *testConverter) handleFrame(frame string) {
+	action, rest, _ := strings.Cut(strings.TrimSpace(frame), " ")
+	rest = strings.TrimSpace(rest)
+	switch action {
+	case "===":
+		verb, test, _ := strings.Cut(rest, " ")
+		c.current = strings.TrimSpace(test)
+		switch verb {
+		case "RUN":
+			c.running[c.current] = true
+			c.event(testEvent{Action: "run", Test: c.current})
+		case "PAUSE", "CONT":
+			c.event(testEvent{Action: strings.ToLower(verb), Test: c.current})
+		}
+		c.output(frame)
+	case "---":
+		result, test, _ := strings.Cut(rest, ": ")
+		test, elapsed := parseTestElapsed(test)
+		c.current = test
+		c.output(frame)
+		if result == "PASS" || result == "FAIL" || result == "SKIP" {
+			delete(c.running, test)
+			c.event(testEvent{Action: strings.ToLower(result), Test: test, Elapsed: elapsed})
+		}
+	default:
+		// The overall result of the package, which isn't part of any test.
+		c.current = ""
+		c.output(frame)
+	}
+}
+
+// parseTestElapsed splits e.g. "TestFoo (0.01s)" into the test name and its duration in seconds.
+func parseTestElapsed(s string) (string, float64) {
+	i := strings.LastIndex(s, " (")
+	if i == -1 || !strings.HasSuffix(s, "s)") {
+		return s, 0
+	}
+	elapsed, err := strconv.ParseFloat(s[i+2:len(s)-2], 64)
+	if err != nil {
+		return s, 0
+	}
+	return s[:i], elapsed
+}
+
+// output records some output from the current test, and passes it through.
+func (c *testConverter) output(output string) {
+	c.event(testEvent{Action: "output", Test: c.current, Output: output})
+	c.passThrough(output)
+}
+
+func (c *testConverter) passThrough(output string) {
+	if c.text != nil {
+		io.WriteString(c.text, output)
+	}
+}
+
+func (c *testConverter) event(e testEvent) {
+	e.Time = time.Now()
+	e.Package = c.pkg
+	c.events = append(c.events, e)
+}
+
+// Close finishes converting once the test binary has exited. Tests that were still running when it did, e.g. because
+// it panicked or TestMain exited early, fail unless the binary succeeded, as do benchmarks which don't report a result.
+func (c *testConverter) Close(passed bool) []testEvent {
+	if len(c.partial) > 0 {
+		c.handleLine(string(c.partial))
+		c.partial = nil
+	}
+	action := "pass"
+	if !passed {
+		action = "fail"
+	}
+	for _, e := range c.events {
+		if e.Action == "run" && c.running[e.Test] {
+			delete(c.running, e.Test)
+			c.event(testEvent{Action: action, Test: e.Test})
+		}
+	}
+	c.event(testEvent{Action: action, Elapsed: time.Since(c.start).Seconds()})
+	return c.events
+}
+
+func stripTestErrorMarkers(s string) string {
+	return strings.NewReplacer(string(testErrorStart), "", string(testErrorEnd), "").Replace(s)
+}
+
+// junitTestSuites is the root of a JUnit XML report
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string       `xml:"name,attr"`
+	Classname string       `xml:"classname,attr"`
+	Time      string       `xml:"time,attr"`
+	Failure   *junitResult `xml:"failure,omitempty"`
+	Skipped   *junitResult `xml:"skipped,omitempty"`
+	SystemOut string       `xml:"system-out,omitempty"`
+}
+
+type junitResult struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// buildJUnitTestSuites converts the events into a JUnit report, with a test case for each test and subtest in the
+// order they started. The output of each test is recorded against it, and anything else against the suite.
+func buildJUnitTestSuites(events []testEvent) *junitTestSuites {
+	suite := junitTestSuite{}
+	indices := map[string]int{}
+	output := map[string]*strings.Builder{}
+	for _, e := range events {
+		if suite.Name == "" {
+			suite.Name = e.Package
+			suite.Timestamp = e.Time.UTC().Format(time.RFC3339)
+		}
+		if e.Test == "" {
+			if e.Action == "output" {
+				suite.SystemOut += e.Output
+			} else if e.Action == "pass" || e.Action == "fail" {
+				suite.Time = formatJUnitTime(e.Elapsed)
+			}
+			continue
+		}
+		i, present := indices[e.Test]
+		if !present {
+			i = len(suite.TestCases)
+			indices[e.Test] = i
+			suite.TestCases = append(suite.TestCases, junitTestCase{Name: e.Test, Classname: e.Package, Time: formatJUnitTime(0)})
+			output[e.Test] = &strings.Builder{}
+		}
+		tc := &suite.TestCases[i]
+		switch e.Action {
+		case "output":
+			output[e.Test].WriteString(e.Output)
+		case "pass":
+			tc.Time = formatJUnitTime(e.Elapsed)
+		case "fail":
+			tc.Time = formatJUnitTime(e.Elapsed)
+			tc.Failure = &junitResult{Message: "Failed"}
+		case "skip":
+			tc.Time = formatJUnitTime(e.Elapsed)
+			tc.Skipped = &junitResult{Message: "Skipped"}
+		}
+	}
+	for i := range suite.TestCases {
+		tc := &suite.TestCases[i]
+		out := output[tc.Name].String()
+		if tc.Failure != nil {
+			tc.Failure.Contents = out
+			suite.Failures++
+		} else if tc.Skipped != nil {
+			tc.Skipped.Contents = out
+			suite.Skipped++
+		} else {
+			tc.SystemOut = out
+		}
+	}
+	suite.Tests = len(suite.TestCases)
+	return &junitTestSuites{Suites: []junitTestSuite{suite}}
+}
+
+func formatJUnitTime(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
@@ -0,0 +1,124 @@
+package testresults
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testOutput is the output of a test binary run with -test.v=test2json
+const testOutput = "\x16=== RUN   TestA\n" +
+	"    a_test.go:3: hello\n" +
+	"\x16=== RUN   TestA/sub\n" +
+	"\x16=== PAUSE TestA/sub\n" +
+	"\x16=== NAME  TestA\n" +
+	"\x16=== RUN   TestA/skip\n" +
+	"    a_test.go:3: nope\n" +
+	"\x16--- SKIP: TestA/skip (0.00s)\n" +
+	"\x16=== NAME  TestA\n" +
+	"\x16=== CONT  TestA/sub\n" +
+	"partial\x16=== NAME  TestA/sub\n" +
+	"    a_test.go:3: in sub\n" +
+	"\x16--- PASS: TestA/sub (0.01s)\n" +
+	"\x16--- PASS: TestA (0.02s)\n" +
+	"\x16=== NAME  \n" +
+	"\x16=== RUN   TestB\n" +
+	"\x0f    a_test.go:4: bad\x0e\n" +
+	"\x16--- FAIL: TestB (0.50s)\n" +
+	"\x16=== NAME  \n" +
+	"\x16FAIL\n"
+
+func convert(t *testing.T, output string, passed bool) (string, []testEvent) {
+	t.Helper()
+	var text bytes.Buffer
+	c := newTestConverter("example.com/pkg", &text)
+	// Write it in awkward chunks to make sure lines are put back together
+	for len(output) > 0 {
+		n := min(7, len(output))
+		_, err := c.Write([]byte(output[:n]))
+		require.NoError(t, err)
+		output = output[n:]
+	}
+	return text.String(), c.Close(passed)
+}
+
+func TestConverter(t *testing.T) {
+	text, events := convert(t, testOutput, false)
+	assert.NotContains(t, text, "\x16")
+	assert.NotContains(t, text, "\x0f")
+	assert.Contains(t, text, "--- FAIL: TestB (0.50s)\n")
+
+	type result struct {
+		Action  string
+		Test    string
+		Elapsed float64
+	}
+	var results []result
+	output := map[string]string{}
+	for _, e := range events {
+		assert.Equal(t, "example.com/pkg", e.Package)
+		if e.Action == "output" {
+			output[e.Test] += e.Output
+		} else if e.Test != "" || e.Action != "start" {
+			results = append(results, result{Action: e.Action, Test: e.Test, Elapsed: e.Elapsed})
+		}
+	}
+	// The package's elapsed time is measured in real time so we can't know it
+	results[len(results)-1].Elapsed = 0
+	assert.Equal(t, []result{
+		{Action: "run", Test: "TestA"},
+		{Action: "run", Test: "TestA/sub"},
+		{Action: "pause", Test: "TestA/sub"},
+		{Action: "run", Test: "TestA/skip"},
+		{Action: "skip", Test: "TestA/skip"},
+		{Action: "cont", Test: "TestA/sub"},
+		{Action: "pass", Test: "TestA/sub", Elapsed: 0.01},
+		{Action: "pass", Test: "TestA", Elapsed: 0.02},
+		{Action: "run", Test: "TestB"},
+		{Action: "fail", Test: "TestB", Elapsed: 0.5},
+		{Action: "fail"},
+	}, results)
+	assert.Equal(t, "=== RUN   TestA/sub\n=== PAUSE TestA/sub\n=== CONT  TestA/sub\npartial=== NAME  TestA/sub\n    a_test.go:3: in sub\n--- PASS: TestA/sub (0.01s)\n", output["TestA/sub"])
+	assert.Equal(t, "=== RUN   TestB\n    a_test.go:4: bad\n--- FAIL: TestB (0.50s)\n", output["TestB"])
+}
+
+func TestConverterFailsUnfinishedTests(t *testing.T) {
+	_, events := convert(t, "\x16=== RUN   TestPanic\npanic: oh no\n", false)
+	last := events[len(events)-2]
+	assert.Equal(t, "fail", last.Action)
+	assert.Equal(t, "TestPanic", last.Test)
+	assert.Equal(t, "panic: oh no\n", events[len(events)-3].Output)
+}
+
+func TestJUnit(t *testing.T) {
+	_, events := convert(t, testOutput, false)
+	suites := buildJUnitTestSuites(events)
+	require.Len(t, suites.Suites, 1)
+	suite := suites.Suites[0]
+	assert.Equal(t, "example.com/pkg", suite.Name)
+	assert.Equal(t, 4, suite.Tests)
+	assert.Equal(t, 1, suite.Failures)
+	assert.Equal(t, 1, suite.Skipped)
+	assert.Equal(t, "=== NAME  \n=== NAME  \nFAIL\n", suite.SystemOut)
+
+	names := make([]string, len(suite.TestCases))
+	for i, tc := range suite.TestCases {
+		names[i] = tc.Name
+		assert.Equal(t, "example.com/pkg", tc.Classname)
+	}
+	assert.Equal(t, []string{"TestA", "TestA/sub", "TestA/skip", "TestB"}, names)
+	assert.Equal(t, "0.010", suite.TestCases[1].Time)
+	assert.Nil(t, suite.TestCases[1].Failure)
+	assert.Contains(t, suite.TestCases[1].SystemOut, "in sub")
+	require.NotNil(t, suite.TestCases[2].Skipped)
+	assert.Contains(t, suite.TestCases[2].Skipped.Contents, "nope")
+	require.NotNil(t, suite.TestCases[3].Failure)
+	assert.Contains(t, suite.TestCases[3].Failure.Contents, "a_test.go:4: bad")
+
+	b, err := xml.Marshal(suites)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `<testcase name="TestB" classname="example.com/pkg" time="0.500"><failure message="Failed">`)
+}
@@ -1,12 +1,14 @@
 package test
 
 import (
+	_ "embed"
 	"fmt"
 	"go/ast"
 	"go/doc"
 	"go/parser"
 	"go/token"
 	"os"
+	"sort"
 	"strings"
 	"text/template"
 	"unicode"
@@ -15,26 +17,124 @@ import (
 
 type testDescr struct {
 	Package        string
+	Alias          string
 	Main           string
 	TestFunctions  []string
 	BenchFunctions []string
 	FuzzFunctions  []string
 	Examples       []*doc.Example
+}
+
+// testMain describes the test main, which runs the tests in the internal test package and the external one.
+type testMain struct {
+	Packages       []*testDescr
+	MainPackage    *testDescr
 	Imports        []string
 	Benchmark      bool
+	TestPackage    string
+	ResultsFormat  string
+	CoverPackages  []string
+	LegacyCoverage bool
+	Watchdog       bool
+	CheckLeaks     bool
+	ShardSrc       string
 }
 
-// WriteTestMain templates a test main file from the given sources to the given output file.
-func WriteTestMain(testPackage string, sources []string, output string, benchmark bool) error {
-	testDescr, err := parseTestSources(sources)
+// testResultsSrc is the source of the testresults package, which is copied into the test main to write structured
+// results.
+//
+//go:embed testresults/testresults.go
+var testResultsSrc string
+
+// diagnosticsSrc is the source of the diagnostics package, which is copied into the test main to dump goroutines
+// before the test times out or check for leaked ones.
+//
+//go:embed diagnostics/diagnostics.go
+var diagnosticsSrc string
+
+// shardSrc is the source of the shard package, which assigns tests to shards. The code after its imports is pasted into
+// the test main.
+//
+//go:embed shard/shard.go
+var shardSrc string
+
+// WriteTestMain templates a test main file from the given sources to the given output file. As with go test, the
+// sources can be a mix of the internal test package, imported from testPackage, and the external one with a _test
+// suffix, imported from xtestPackage. If the test is external, all of the sources are in the external package, which
+// is imported from testPackage. Any cover packages are registered for coverage, as with `go test -coverpkg`, through
+// runtime/coverage if legacyCoverage is set, as Go before 1.23 needs, or internal/coverage/cfile otherwise. If a
+// results format is given, which can only be junit, the test main writes results in that format to $RESULTS_FILE,
+// and the code to do so is written to resultsOutput. If watchdog is set, the test main dumps the stacks of all
+// goroutines to $TEST_GOROUTINES_FILE shortly before the test times out, and if checkLeaks is set it fails if any
+// goroutines are left running after the tests. The code for either is written to diagnosticsOutput.
+func WriteTestMain(testPackage, xtestPackage string, sources []string, output string, external, benchmark bool, coverPackages []string, legacyCoverage bool, resultsFormat, resultsOutput string, watchdog, checkLeaks bool, diagnosticsOutput string) error {
+	internalSources, xtestSources, err := splitTestSources(sources, external)
 	if err != nil {
 		return err
 	}
-	if len(testDescr.TestFunctions) > 0 || len(testDescr.BenchFunctions) > 0 || len(testDescr.Examples) > 0 || len(testDescr.FuzzFunctions) > 0 || testDescr.Main != "" {
-		testDescr.Imports = []string{fmt.Sprintf("%s \"%s\"", testDescr.Package, testPackage)}
+	if external {
+		xtestPackage = testPackage
+	} else if len(xtestSources) > 0 && xtestPackage == "" {
+		return fmt.Errorf("no import path given for the external test package of %s", strings.Join(xtestSources, ", "))
+	}
+	main := testMain{
+		Benchmark:      benchmark,
+		TestPackage:    testPackage,
+		CoverPackages:  coverPackages,
+		LegacyCoverage: legacyCoverage,
+		ResultsFormat:  resultsFormat,
+		Watchdog:       watchdog,
+		CheckLeaks:     checkLeaks,
+	}
+	if !benchmark {
+		_, main.ShardSrc, _ = strings.Cut(shardSrc, "\n)\n")
+	}
+	// As with go test, the test packages are imported under fixed names so they can't clash with anything in the main.
+	for _, pkg := range []struct {
+		alias, importPath string
+		sources           []string
+	}{
+		{alias: "_test", importPath: testPackage, sources: internalSources},
+		{alias: "_xtest", importPath: xtestPackage, sources: xtestSources},
+	} {
+		if len(pkg.sources) == 0 {
+			continue
+		}
+		descr, err := parseTestSources(pkg.sources)
+		if err != nil {
+			return err
+		}
+		descr.Alias = pkg.alias
+		main.Packages = append(main.Packages, &descr)
+		if len(descr.TestFunctions) > 0 || len(descr.BenchFunctions) > 0 || len(descr.Examples) > 0 || len(descr.FuzzFunctions) > 0 || descr.Main != "" {
+			main.Imports = append(main.Imports, fmt.Sprintf("%s \"%s\"", descr.Alias, pkg.importPath))
+		}
+		if descr.Main != "" {
+			if main.MainPackage != nil {
+				return fmt.Errorf("multiple definitions of TestMain")
+			}
+			main.MainPackage = &descr
+		}
 	}
 
-	testDescr.Benchmark = benchmark
+	// The testresults and diagnostics packages are copied in as extra files of package main. Their package level names
+	// share a scope with the generated main's declarations and file scope imports, so they mustn't use any of those
+	// names, i.e. the test package aliases and anything prefixed with _gostdlib_.
+	if resultsFormat != "" {
+		if resultsFormat != "junit" {
+			return fmt.Errorf("unknown test results format %s", resultsFormat)
+		}
+		src := strings.Replace(testResultsSrc, "\npackage testresults\n", "\npackage main\n", 1)
+		if err := os.WriteFile(resultsOutput, []byte(src), 0644); err != nil {
+			return err
+		}
+	}
+	if watchdog || checkLeaks {
+		src := strings.Replace(diagnosticsSrc, "\npackage diagnostics\n", "\npackage main\n", 1)
+		if err := os.WriteFile(diagnosticsOutput, []byte(src), 0644); err != nil {
+			return err
+		}
+	}
 
 	f, err := os.Create(output)
 	if err != nil {
@@ -42,9 +142,30 @@ func WriteTestMain(testPackage string, sources []string, output string, benchmar
 	}
 	defer f.Close()
 	// This might be consumed by other things.
-	fmt.Printf("Package: %s\n", testDescr.Package)
+	fmt.Printf("Package: %s\n", main.Packages[0].Package)
 
-	return testMainTmpl.Execute(f, testDescr)
+	return testMainTmpl.Execute(f, main)
+}
+
+// splitTestSources splits the sources into those in the internal test package and those in the external one, which
+// are _test.go files in a package with a _test suffix. All of the sources are external if the test is.
+func splitTestSources(sources []string, external bool) (internal, xtest []string, err error) {
+	if external {
+		return nil, sources, nil
+	}
+	for _, source := range sources {
+		f, err := parser.ParseFile(token.NewFileSet(), source, nil, parser.PackageClauseOnly)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %s: %s\n", source, err)
+			return nil, nil, err
+		}
+		if strings.HasSuffix(source, "_test.go") && strings.HasSuffix(f.Name.Name, "_test") {
+			xtest = append(xtest, source)
+		} else {
+			internal = append(internal, source)
+		}
+	}
+	return internal, xtest, nil
 }
 
 // parseTestSources parses the test sources and returns the package and set of test functions in them.
@@ -57,10 +178,6 @@ func parseTestSources(sources []string) (testDescr, error) {
 			return descr, err
 		}
 		descr.Package = f.Name.Name
-		// If we're testing main, we will get errors from it clashing with func main.
-		if descr.Package == "main" {
-			descr.Package = "_main"
-		}
 		for _, d := range f.Decls {
 			if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil {
 				name := fd.Name.String()
@@ -75,8 +192,15 @@ func parseTestSources(sources []string) (testDescr, error) {
 				}
 			}
 		}
-		// Get doc to find the examples for us :)
-		descr.Examples = append(descr.Examples, doc.Examples(f)...)
+		// Get doc to find the examples for us :) It returns them sorted by name, but go test runs them in source order.
+		examples := doc.Examples(f)
+		sort.Slice(examples, func(i, j int) bool { return examples[i].Order < examples[j].Order })
+		for _, example := range examples {
+			// As with go test, examples without an output comment are compiled but not run.
+			if example.Output != "" || example.EmptyOutput {
+				descr.Examples = append(descr.Examples, example)
+			}
+		}
 	}
 	return descr, nil
 }
@@ -127,9 +251,20 @@ package main
 
 import (
 	_gostdlib_os "os"
-	{{ if not .Benchmark }}_gostdlib_strings "strings"{{ end }}
+{{ if not .Benchmark }}
+	_gostdlib_fnv "hash/fnv"
+	_gostdlib_strconv "strconv"
+	_gostdlib_strings "strings"
+{{ end }}
 	_gostdlib_testing "testing"
 	_gostdlib_testdeps "testing/internal/testdeps"
+{{ if .CoverPackages }}
+{{ if .LegacyCoverage }}
+	_ "unsafe"
+{{ else }}
+	_gostdlib_cfile "internal/coverage/cfile"
+{{ end }}
+{{ end }}
 
 {{range .Imports}}
 	{{.}}
@@ -137,32 +272,117 @@ import (
 )
 
 var tests = []_gostdlib_testing.InternalTest{
-{{range .TestFunctions}}
-	{"{{.}}", {{$.Package}}.{{.}}},
-{{end}}
+{{range $pkg := .Packages}}{{range .TestFunctions}}
+	{"{{.}}", {{$pkg.Alias}}.{{.}}},
+{{end}}{{end}}
 }
 var examples = []_gostdlib_testing.InternalExample{
-{{range .Examples}}
-	{"{{.Name}}", {{$.Package}}.Example{{.Name}}, {{.Output | printf "%q"}}, {{.Unordered}}},
-{{end}}
+{{range $pkg := .Packages}}{{range .Examples}}
+	{"Example{{.Name}}", {{$pkg.Alias}}.Example{{.Name}}, {{.Output | printf "%q"}}, {{.Unordered}}},
+{{end}}{{end}}
 }
 
 var benchmarks = []_gostdlib_testing.InternalBenchmark{
-{{range .BenchFunctions}}
-	{"{{.}}", {{$.Package}}.{{.}}},
-{{end}}
+{{range $pkg := .Packages}}{{range .BenchFunctions}}
+	{"{{.}}", {{$pkg.Alias}}.{{.}}},
+{{end}}{{end}}
 }
 
 var fuzzTargets = []_gostdlib_testing.InternalFuzzTarget{
-{{ range .FuzzFunctions }}
-	{"{{.}}", {{$.Package}}.{{.}}},
-{{ end }}
+{{range $pkg := .Packages}}{{range .FuzzFunctions}}
+	{"{{.}}", {{$pkg.Alias}}.{{.}}},
+{{end}}{{end}}
 }
 
 var testDeps = _gostdlib_testdeps.TestDeps{}
+{{if .CoverPackages}}
+{{if .LegacyCoverage}}
+//go:linkname runtime_coverage_processCoverTestDir runtime/coverage.processCoverTestDir
+func runtime_coverage_processCoverTestDir(dir string, cfile string, cmode string, cpkgs string) error
+
+//go:linkname testing_registerCover2 testing.registerCover2
+func testing_registerCover2(mode string, tearDown func(coverprofile string, gocoverdir string) (string, error), snapcov func() float64)
+
+//go:linkname runtime_coverage_markProfileEmitted runtime/coverage.markProfileEmitted
+func runtime_coverage_markProfileEmitted(val bool)
+
+//go:linkname runtime_coverage_snapshot runtime/coverage.snapshot
+func runtime_coverage_snapshot() float64
+
+func coverTearDown(coverprofile string, gocoverdir string) (string, error) {
+	var err error
+	if gocoverdir == "" {
+		gocoverdir, err = _gostdlib_os.MkdirTemp("", "gocoverdir")
+		if err != nil {
+			return "error setting GOCOVERDIR: bad os.MkdirTemp return", err
+		}
+		defer _gostdlib_os.RemoveAll(gocoverdir)
+	}
+	runtime_coverage_markProfileEmitted(true)
+	if err := runtime_coverage_processCoverTestDir(gocoverdir, coverprofile, "set", " in {{range $i, $pkg := .CoverPackages}}{{if $i}}, {{end}}{{$pkg}}{{end}}"); err != nil {
+		return "error generating coverage report", err
+	}
+	return "", nil
+}
+
+func init() {
+	testing_registerCover2("set", coverTearDown, runtime_coverage_snapshot)
+}
+{{else}}
+func init() {
+	_gostdlib_testdeps.CoverMode = "set"
+	_gostdlib_testdeps.Covered = " in {{range $i, $pkg := .CoverPackages}}{{if $i}}, {{end}}{{$pkg}}{{end}}"
+	_gostdlib_testdeps.CoverSelectedPackages = []string{
+{{range .CoverPackages}}
+		{{printf "%q" .}},
+{{end}}
+	}
+	_gostdlib_testdeps.CoverSnapshotFunc = _gostdlib_cfile.Snapshot
+	_gostdlib_testdeps.CoverProcessTestDirFunc = _gostdlib_cfile.ProcessCoverTestDir
+	_gostdlib_testdeps.CoverMarkProfileEmittedFunc = _gostdlib_cfile.MarkProfileEmitted
+}
+{{end}}
+{{end}}
+{{if not .Benchmark}}
+{{.ShardSrc}}
+// shardTests removes the tests, examples and fuzz targets that don't belong to this shard.
+func shardTests() {
+	var shardedTests []_gostdlib_testing.InternalTest
+	for _, test := range tests {
+		if inTestShard(test.Name) {
+			shardedTests = append(shardedTests, test)
+		}
+	}
+	var shardedExamples []_gostdlib_testing.InternalExample
+	for _, example := range examples {
+		if inTestShard(example.Name) {
+			shardedExamples = append(shardedExamples, example)
+		}
+	}
+	var shardedFuzzTargets []_gostdlib_testing.InternalFuzzTarget
+	for _, target := range fuzzTargets {
+		if inTestShard(target.Name) {
+			shardedFuzzTargets = append(shardedFuzzTargets, target)
+		}
+	}
+	tests, examples, fuzzTargets = shardedTests, shardedExamples, shardedFuzzTargets
+}
+{{end}}
 
 func internalMain() int {
 	args := []string{_gostdlib_os.Args[0], "-test.v"}
+{{if .ResultsFormat}}
+	// Frame the test output so it can be converted into structured results reliably.
+	if _gostdlib_os.Getenv(testResultsChildEnv) != "" {
+		args[1] = "-test.v=test2json"
+	}
+{{end}}
+{{if .CoverPackages}}
+	// Write the coverage data where Please expects it, rather than to a temporary directory.
+	if coverDir := _gostdlib_os.Getenv("GOCOVERDIR"); coverDir != "" {
+		args = append(args, "-test.gocoverdir=" + coverDir)
+	}
+{{end}}
 
 {{if not .Benchmark}}
     testVar := _gostdlib_os.Getenv("TESTS")
@@ -171,22 +391,38 @@ func internalMain() int {
 		args = append(args, "-test.run", testVar)
     }
     _gostdlib_os.Args = append(args, _gostdlib_os.Args[1:]...)
+	shardTests()
 	m := _gostdlib_testing.MainStart(testDeps, tests, nil, fuzzTargets, examples)
 {{else}}
 	args = append(args, "-test.bench", ".*")
 	_gostdlib_os.Args = append(args, _gostdlib_os.Args[1:]...)
 	m := _gostdlib_testing.MainStart(testDeps, nil, benchmarks, fuzzTargets, nil)
 {{end}}
+{{if .Watchdog}}
+	defer startTestWatchdog()()
+{{end}}
 
-{{if .Main}}
-	{{.Package}}.{{.Main}}(m)
-    return 0
+{{with .MainPackage}}
+	{{.Alias}}.{{.Main}}(m)
+	code := 0
 {{else}}
-	return m.Run()
+	code := m.Run()
 {{end}}
+{{if .CheckLeaks}}
+	if code == 0 && !checkGoroutineLeaks() {
+		code = 1
+	}
+{{end}}
+	return code
 }
 
 func main() {
+{{if .ResultsFormat}}
+	// Rerun ourselves to run the tests, so we can write their results to where Please reads them from.
+	if resultsFile := _gostdlib_os.Getenv("RESULTS_FILE"); resultsFile != "" && _gostdlib_os.Getenv(testResultsChildEnv) == "" {
+		_gostdlib_os.Exit(runTestsWithResults({{printf "%q" .TestPackage}}, {{printf "%q" .ResultsFormat}}, resultsFile))
+	}
+{{end}}
 	_gostdlib_os.Exit(internalMain())
 }
 `))
@@ -4,6 +4,9 @@ import (
 	"go/parser"
 	"go/token"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -44,7 +47,7 @@ func TestParseTestSourcesFailsGracefully(t *testing.T) {
 }
 
 func TestWriteTestMain(t *testing.T) {
-	err := WriteTestMain("test_pkg", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false)
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, nil, false, "", "", false, false, "")
 	assert.NoError(t, err)
 	// It's not really practical to assert the contents of the file in great detail.
 	// We'll do the obvious thing of asserting that it is valid Go source.
@@ -54,7 +57,7 @@ func TestWriteTestMain(t *testing.T) {
 }
 
 func TestWriteTestMainWithBenchmark(t *testing.T) {
-	err := WriteTestMain("test_package", []string{"tools/please_go/test/test_data/bench/example_benchmark_test.go"}, "test.go", true)
+	err := WriteTestMain("test_package", "", []string{"tools/please_go/test/test_data/bench/example_benchmark_test.go"}, "test.go", false, true, nil, false, "", "", false, false, "")
 	assert.NoError(t, err)
 	// It's not really practical to assert the contents of the file in great detail.
 	// We'll do the obvious thing of asserting that it is valid Go source.
@@ -66,3 +69,229 @@ func TestWriteTestMainWithBenchmark(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(test), "BenchmarkExample")
 }
+
+func TestWriteTestMainWithResults(t *testing.T) {
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, nil, false, "junit", "test_results.go", false, false, "")
+	assert.NoError(t, err)
+	defer os.Remove("test_results.go")
+
+	test, err := os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(test), `runTestsWithResults("test_pkg", "junit", resultsFile)`)
+
+	// The code to write the results must be in the same package as the main
+	f, err := parser.ParseFile(token.NewFileSet(), "test_results.go", nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", f.Name.Name)
+	assert.NotNil(t, f.Scope.Lookup("runTestsWithResults"))
+}
+
+func TestWriteTestMainWithUnknownResultsFormat(t *testing.T) {
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, nil, false, "tap", "test_results.go", false, false, "")
+	assert.ErrorContains(t, err, "unknown test results format tap")
+}
+
+func TestWriteTestMainWithDiagnostics(t *testing.T) {
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, nil, false, "", "", true, true, "test_diagnostics.go")
+	assert.NoError(t, err)
+	defer os.Remove("test_diagnostics.go")
+
+	test, err := os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(test), "defer startTestWatchdog()()")
+	assert.Contains(t, string(test), "!checkGoroutineLeaks()")
+
+	// The code for the diagnostics must be in the same package as the main
+	f, err := parser.ParseFile(token.NewFileSet(), "test_diagnostics.go", nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", f.Name.Name)
+	assert.NotNil(t, f.Scope.Lookup("startTestWatchdog"))
+	assert.NotNil(t, f.Scope.Lookup("checkGoroutineLeaks"))
+
+	// Neither is there unless asked for
+	err = WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, nil, false, "", "", false, false, "")
+	assert.NoError(t, err)
+	test, err = os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(test), "startTestWatchdog")
+	assert.NotContains(t, string(test), "checkGoroutineLeaks")
+}
+
+func TestWriteTestMainShardsTests(t *testing.T) {
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, nil, false, "", "", false, false, "")
+	assert.NoError(t, err)
+
+	test, err := os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(test), "func inTestShard(name string) bool")
+	assert.Contains(t, string(test), "shardTests()")
+
+	// Benchmarks aren't sharded
+	err = WriteTestMain("test_package", "", []string{"tools/please_go/test/test_data/bench/example_benchmark_test.go"}, "test.go", false, true, nil, false, "", "", false, false, "")
+	assert.NoError(t, err)
+	test, err = os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(test), "shardTests()")
+}
+
+func TestWriteTestMainExternal(t *testing.T) {
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", true, false, nil, false, "", "", false, false, "")
+	assert.NoError(t, err)
+
+	test, err := os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(test), `_xtest "test_pkg"`)
+	assert.Contains(t, string(test), `{"TestReadPkgdef", _xtest.TestReadPkgdef}`)
+}
+
+func TestFindCoverPackages(t *testing.T) {
+	pkgs, err := FindCoverPackages("tools/please_go/test/test_data/cover", []string{"third_party/go"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"github.com/please-build/go-rules/lib", "github.com/please-build/go-rules/lib/util"}, pkgs)
+
+	pkgs, err = FindCoverPackages("tools/please_go/test/test_data/cover", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"github.com/example/dep", "github.com/please-build/go-rules/lib", "github.com/please-build/go-rules/lib/util"}, pkgs)
+}
+
+func TestWriteTestMainWithCoverage(t *testing.T) {
+	coverPackages := []string{"test_pkg", "github.com/please-build/go-rules/lib"}
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, coverPackages, false, "", "", false, false, "")
+	assert.NoError(t, err)
+
+	f, err := parser.ParseFile(token.NewFileSet(), "test.go", nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", f.Name.Name)
+
+	test, err := os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(test), `_gostdlib_cfile "internal/coverage/cfile"`)
+	assert.Contains(t, string(test), `_gostdlib_testdeps.Covered = " in test_pkg, github.com/please-build/go-rules/lib"`)
+	assert.Contains(t, string(test), `"github.com/please-build/go-rules/lib",`)
+
+	// Without any packages to cover, coverage isn't registered at all
+	err = WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, nil, false, "", "", false, false, "")
+	assert.NoError(t, err)
+	test, err = os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(test), "internal/coverage/cfile")
+}
+
+func TestWriteTestMainWithLegacyCoverage(t *testing.T) {
+	coverPackages := []string{"test_pkg", "github.com/please-build/go-rules/lib"}
+	err := WriteTestMain("test_pkg", "", []string{"tools/please_go/test/test_data/test/example_test.go"}, "test.go", false, false, coverPackages, true, "", "", false, false, "")
+	assert.NoError(t, err)
+
+	f, err := parser.ParseFile(token.NewFileSet(), "test.go", nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", f.Name.Name)
+
+	test, err := os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(test), "internal/coverage/cfile")
+	assert.NotContains(t, string(test), "_gostdlib_testdeps.Cover")
+	assert.Contains(t, string(test), "//go:linkname runtime_coverage_processCoverTestDir runtime/coverage.processCoverTestDir")
+	assert.Contains(t, string(test), `" in test_pkg, github.com/please-build/go-rules/lib"`)
+	assert.Contains(t, string(test), `testing_registerCover2("set", coverTearDown, runtime_coverage_snapshot)`)
+}
+
+func TestPleaseGoTestWithCoverage(t *testing.T) {
+	for version, legacy := range map[string]bool{"1.22.5": true, "1.23.0": false} {
+		t.Run(version, func(t *testing.T) {
+			// A stand-in for the go tool, which only needs to report its version.
+			goTool := filepath.Join(t.TempDir(), "go")
+			err := os.WriteFile(goTool, []byte("#!/bin/sh\necho go version go"+version+" linux/amd64\n"), 0755)
+			assert.NoError(t, err)
+
+			PleaseGoTest(goTool, "tools/please_go/test/test_data/cover", "test_pkg", "", "test.go", []string{"tools/please_go/test/test_data/test/example_test.go"}, []string{"third_party/go"}, false, false, "", "", false, false, "")
+			test, err := os.ReadFile("test.go")
+			assert.NoError(t, err)
+			assert.Contains(t, string(test), `" in test_pkg, github.com/please-build/go-rules/lib, github.com/please-build/go-rules/lib/util"`)
+			assert.Equal(t, legacy, strings.Contains(string(test), "runtime/coverage.processCoverTestDir"))
+			assert.Equal(t, !legacy, strings.Contains(string(test), "internal/coverage/cfile"))
+		})
+	}
+}
+
+func TestWriteTestMainMixed(t *testing.T) {
+	sources := []string{
+		"tools/please_go/test/test_data/mixed/mixed_ext_test.go",
+		"tools/please_go/test/test_data/mixed/mixed_test.go",
+	}
+	err := WriteTestMain("test_pkg", "test_pkg_xtest", sources, "test.go", false, false, nil, false, "", "", false, false, "")
+	assert.NoError(t, err)
+
+	f, err := parser.ParseFile(token.NewFileSet(), "test.go", nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", f.Name.Name)
+
+	test, err := os.ReadFile("test.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(test), `_test "test_pkg"`)
+	assert.Contains(t, string(test), `_xtest "test_pkg_xtest"`)
+	// The internal package's tests come first, as they do with go test
+	assert.Regexp(t, `(?s){"TestInternal", _test.TestInternal}.*{"TestExternal", _xtest.TestExternal}`, string(test))
+	assert.Regexp(t, `(?s){"ExampleInternal", _test.ExampleInternal,.*{"ExampleExternal", _xtest.ExampleExternal,`, string(test))
+	assert.Contains(t, string(test), "_xtest.TestMain(m)")
+}
+
+func TestWriteTestMainMixedNeedsXTestPackage(t *testing.T) {
+	sources := []string{
+		"tools/please_go/test/test_data/mixed/mixed_ext_test.go",
+		"tools/please_go/test/test_data/mixed/mixed_test.go",
+	}
+	err := WriteTestMain("test_pkg", "", sources, "test.go", false, false, nil, false, "", "", false, false, "")
+	assert.ErrorContains(t, err, "no import path given for the external test package")
+}
+
+func TestSplitTestSources(t *testing.T) {
+	sources := []string{
+		"tools/please_go/test/test_data/mixed/mixed_ext_test.go",
+		"tools/please_go/test/test_data/mixed/mixed_test.go",
+	}
+	internal, xtest, err := splitTestSources(sources, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tools/please_go/test/test_data/mixed/mixed_test.go"}, internal)
+	assert.Equal(t, []string{"tools/please_go/test/test_data/mixed/mixed_ext_test.go"}, xtest)
+
+	// Everything is external in an external test
+	internal, xtest, err = splitTestSources(sources, true)
+	assert.NoError(t, err)
+	assert.Empty(t, internal)
+	assert.Equal(t, sources, xtest)
+}
+
+// TestWriteTestMainExamples checks the examples registered for each package in test_data/examples against the
+// examples.golden file in it.
+func TestWriteTestMainExamples(t *testing.T) {
+	dirs, err := filepath.Glob("tools/please_go/test/test_data/examples/*")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, dirs)
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			sources, err := filepath.Glob(filepath.Join(dir, "*.go"))
+			assert.NoError(t, err)
+			err = WriteTestMain("test_pkg", "test_pkg_xtest", sources, "test.go", false, false, nil, false, "", "", false, false, "")
+			assert.NoError(t, err)
+
+			test, err := os.ReadFile("test.go")
+			assert.NoError(t, err)
+			golden, err := os.ReadFile(filepath.Join(dir, "examples.golden"))
+			assert.NoError(t, err)
+			assert.Equal(t, string(golden), registeredExamples(string(test)))
+		})
+	}
+}
+
+// registeredExamples returns the examples registered in a test main, one per line.
+func registeredExamples(main string) string {
+	_, examples, _ := strings.Cut(main, "var examples = []_gostdlib_testing.InternalExample{\n")
+	examples, _, _ = strings.Cut(examples, "\n}\n")
+	var b strings.Builder
+	for _, line := range strings.Split(examples, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}